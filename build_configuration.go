@@ -3,4 +3,134 @@ package main
 type BuildConfiguration struct {
 	Images  []Image `yaml:"build"`
 	Threads int     `yaml:"threads"`
+
+	// KeepGoing makes build proceed with every image that doesn't depend on failed one
+	KeepGoing bool `yaml:"keepGoing,omitempty"`
+
+	// LogDir is a folder where every image gets its own build log
+	LogDir string `yaml:"logDir,omitempty"`
+
+	// Output controls how build output of images is printed to the console
+	Output OutputConfiguration `yaml:"output,omitempty"`
+
+	// Incremental makes build skip images that didn't change since they were built last time
+	Incremental bool `yaml:"incremental,omitempty"`
+
+	// StateFile stores fingerprints of built images for incremental builds, .krane.state if not set
+	StateFile string `yaml:"stateFile,omitempty"`
+
+	// Builder is a default backend for all images, docker is used if not set
+	Builder string `yaml:"builder,omitempty"`
+
+	// Push controls whether built images are pushed to registries, and how
+	Push PushConfiguration `yaml:"push,omitempty"`
+
+	// Include lists other configuration files, relative to this one. Their images are built as a part of the same graph
+	Include []string `yaml:"include,omitempty"`
+
+	// Vars are default values of ${VAR} variables used within configuration
+	Vars map[string]string `yaml:"vars,omitempty"`
+
+	// Since limits build to images affected by files changed since this git ref, and their descendants
+	Since string `yaml:"-"`
+
+	// Dir is the folder of the configuration file, changes for Since are taken from git repository it's in
+	Dir string `yaml:"-"`
+
+	// Targets limit build to images matching them, either by name or by glob pattern, plus their ancestors
+	Targets []string `yaml:"-"`
+
+	// Only builds targets without their ancestors
+	Only bool `yaml:"-"`
+
+	// Downstream builds everything that depends on targets as well
+	Downstream bool `yaml:"-"`
+
+	// BuildArgs come from the command line, and override build args of every image
+	BuildArgs map[string]string `yaml:"-"`
+}
+
+type OutputConfiguration struct {
+	// NoPrefix disables [image] prefix in front of every line
+	NoPrefix bool `yaml:"noPrefix,omitempty"`
+
+	// Color is one of: auto, always, never
+	Color string `yaml:"color,omitempty"`
+
+	// Quiet suppresses build output on the console. It's still captured into log files
+	Quiet bool `yaml:"quiet,omitempty"`
+}
+
+type PushConfiguration struct {
+	// Enabled makes every image pushed once it's built, unless image says otherwise
+	Enabled bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+
+	// Threads is a number of images pushed in parallel, 2 if not set
+	Threads int `yaml:"threads,omitempty" json:"threads,omitempty"`
+
+	// Retries is a number of times failed push is retried
+	Retries int `yaml:"retries,omitempty" json:"retries,omitempty"`
+
+	// AfterAll delays pushes till every image is built successfully, so nothing is pushed if anything fails
+	AfterAll bool `yaml:"afterAll,omitempty" json:"afterAll,omitempty"`
+}
+
+/*
+	This method returns name of the backend that builds given image
+*/
+func (bc BuildConfiguration) BackendOf(image Image) string {
+	if len(image.Builder) > 0 {
+		return image.Builder
+	}
+
+	if len(bc.Builder) > 0 {
+		return bc.Builder
+	}
+
+	return DefaultBuilder
+}
+
+/*
+	This method creates every backend used by this configuration
+*/
+func (bc BuildConfiguration) Backends() (backends map[string]Builder, err error) {
+	backends = make(map[string]Builder)
+	for _, v := range bc.Images {
+		name := bc.BackendOf(v)
+		if _, has := backends[name]; has {
+			continue
+		}
+
+		backends[name], err = newBuilder(name)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+/*
+	This method returns image with settings of this configuration applied to it, exactly as it's going to be built
+*/
+func (bc BuildConfiguration) resolve(image Image) Image {
+	image.BuildArgs = image.EffectiveBuildArgs(bc.BuildArgs)
+	image.Builder = bc.BackendOf(image)
+	if image.Push == nil {
+		push := bc.Push.Enabled
+		image.Push = &push
+	}
+
+	return image
+}
+
+/*
+	This method returns location of the incremental build state
+*/
+func (bc BuildConfiguration) StatePath() string {
+	if len(bc.StateFile) > 0 {
+		return bc.StateFile
+	}
+
+	return DefaultStateFile
 }
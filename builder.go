@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const DefaultBuilder = "docker"
+
+// Builder is a backend that builds a single image out of prepared build context
+type Builder interface {
+	// Build builds the image from buildPath, and writes all output to the given writer. ID of the image is returned, if backend knows it
+	Build(image Image, buildPath string, output io.Writer) (id string, err error)
+}
+
+// commander is implemented by backends that run external command, so the command can be shown to the user
+type commander interface {
+	Command(image Image, buildPath string) []string
+}
+
+// inspector is implemented by backends that can read labels of existing images
+type inspector interface {
+	// Label returns value of the label of given image, or empty string if there's no such image or label
+	Label(name string, label string) (string, error)
+}
+
+// pusher is implemented by backends that can push built images to registries
+type pusher interface {
+	// Push pushes the image, writes all output to the given writer, and returns digest of the pushed image
+	Push(image Image, output io.Writer) (digest string, err error)
+}
+
+// tagger is implemented by backends that can add tags to existing images, so unchanged images aren't rebuilt just to be tagged
+type tagger interface {
+	// Tag adds every tag of the image to the image that was built already, and writes all output to the given writer
+	Tag(image Image, output io.Writer) error
+}
+
+// builders holds all known backends by their name
+var builders = map[string]func() (Builder, error){
+	"docker": func() (Builder, error) {
+		return cliBuilder{binary: "docker", args: dockerArgs, inspectArgs: dockerInspectArgs, pushArgs: dockerPushArgs}, nil
+	},
+	"podman": func() (Builder, error) {
+		return cliBuilder{binary: "podman", args: dockerArgs, inspectArgs: dockerInspectArgs, pushArgs: digestFilePushArgs}, nil
+	},
+	"buildah": func() (Builder, error) {
+		return cliBuilder{binary: "buildah", args: buildahArgs, inspectArgs: buildahInspectArgs, pushArgs: digestFilePushArgs}, nil
+	},
+	"engine": func() (Builder, error) {
+		return newEngineBuilder("")
+	},
+}
+
+/*
+	This function creates backend with a given name
+*/
+func newBuilder(name string) (Builder, error) {
+	factory, has := builders[name]
+	if !has {
+		var names []string
+		for k := range builders {
+			names = append(names, k)
+		}
+
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown builder [%v], expected one of: %v", name, strings.Join(names, ", "))
+	}
+
+	return factory()
+}
+
+// cliBuilder builds images by running command line tool
+type cliBuilder struct {
+	binary      string
+	args        func(image Image, buildPath string) []string
+	inspectArgs func(name string, label string) []string
+	pushArgs    func(name string, digestFile string) []string
+}
+
+func (b cliBuilder) Command(image Image, buildPath string) []string {
+	return append([]string{b.binary}, b.args(image, buildPath)...)
+}
+
+func (b cliBuilder) Build(image Image, buildPath string, output io.Writer) (id string, err error) {
+	cmd := exec.Command(b.binary, b.args(image, buildPath)...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err = cmd.Run()
+	return
+}
+
+func (b cliBuilder) Label(name string, label string) (string, error) {
+	output, err := exec.Command(b.binary, b.inspectArgs(name, label)...).Output()
+	if err != nil {
+		// most likely there's no such image
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+/*
+	This method adds every tag of the image to the existing image with command line tool. docker, podman and buildah share the syntax
+*/
+func (b cliBuilder) Tag(image Image, output io.Writer) error {
+	for _, v := range image.Tags {
+		cmd := exec.Command(b.binary, "tag", image.ContainerName, v)
+		cmd.Stdout = output
+		cmd.Stderr = output
+		err := cmd.Run()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+	This method pushes every tag of the image with command line tool. All of them point to the same image, so they share the digest
+*/
+func (b cliBuilder) Push(image Image, output io.Writer) (digest string, err error) {
+	for _, v := range image.References() {
+		digest, err = b.pushReference(v, output)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// pushReference pushes single reference. Digest is taken from the digest file, if tool supports it, or from the tool output otherwise
+func (b cliBuilder) pushReference(reference string, output io.Writer) (digest string, err error) {
+	digestFile, err := ioutil.TempFile("", "*-digest")
+	if err != nil {
+		return
+	}
+	_ = digestFile.Close()
+	defer os.Remove(digestFile.Name())
+
+	// output is kept, so digest can be found in it
+	var captured bytes.Buffer
+	combined := io.MultiWriter(output, &captured)
+	cmd := exec.Command(b.binary, b.pushArgs(reference, digestFile.Name())...)
+	cmd.Stdout = combined
+	cmd.Stderr = combined
+	err = cmd.Run()
+	if err != nil {
+		return
+	}
+
+	content, err := ioutil.ReadFile(digestFile.Name())
+	if err != nil {
+		return
+	}
+
+	if digest = strings.TrimSpace(string(content)); len(digest) > 0 {
+		return
+	}
+
+	return parseDigest(captured.String()), nil
+}
+
+// pushDigest matches digest reported by docker push, i.e. "latest: digest: sha256:... size: 528"
+var pushDigest = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64})`)
+
+// parseDigest returns the last digest reported by docker push, or empty string if there's none
+func parseDigest(output string) string {
+	matches := pushDigest.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	return matches[len(matches)-1][1]
+}
+
+// dockerPushArgs returns arguments of docker push command. Docker can't write digest file, so it's ignored
+func dockerPushArgs(name string, _ string) []string {
+	return []string{"push", name}
+}
+
+// digestFilePushArgs returns arguments of podman and buildah push commands, both write digest into a given file
+func digestFilePushArgs(name string, digestFile string) []string {
+	return []string{"push", "--digestfile", digestFile, name}
+}
+
+// dockerInspectArgs returns arguments of docker command that prints label of the image
+func dockerInspectArgs(name string, label string) []string {
+	return []string{"image", "inspect", "--format", fmt.Sprintf("{{ index .Config.Labels %q }}", label), name}
+}
+
+// buildahInspectArgs returns arguments of buildah command that prints label of the image
+func buildahInspectArgs(name string, label string) []string {
+	return []string{"inspect", "--type", "image", "--format", fmt.Sprintf("{{ index .OCIv1.Config.Labels %q }}", label), name}
+}
+
+// dockerArgs returns arguments of docker build command for a given image
+func dockerArgs(image Image, buildPath string) (args []string) {
+	args = []string{"build"}
+	if image.ForbidCache {
+		args = append(args, "--no-cache")
+	}
+
+	if image.Pull {
+		args = append(args, "--pull")
+	}
+
+	for _, k := range sortedKeys(image.BuildArgs) {
+		args = append(args, "--build-arg", k+"="+image.BuildArgs[k])
+	}
+
+	for _, k := range sortedKeys(image.Labels) {
+		args = append(args, "--label", k+"="+image.Labels[k])
+	}
+
+	if len(image.Target) > 0 {
+		args = append(args, "--target", image.Target)
+	}
+
+	if len(image.Platform) > 0 {
+		args = append(args, "--platform", image.Platform)
+	}
+
+	if len(image.Network) > 0 {
+		args = append(args, "--network", image.Network)
+	}
+
+	for _, v := range image.Secrets {
+		args = append(args, "--secret", v)
+	}
+
+	for _, v := range image.SSH {
+		args = append(args, "--ssh", v)
+	}
+
+	// Dockerfile can be located anywhere, not only in the root of build context
+	if dockerfile := image.ResolvedDockerfile(); dockerfile != filepath.Join(buildPath, "Dockerfile") {
+		args = append(args, "-f", dockerfile)
+	}
+
+	args = append(args, image.ExtraArgs...)
+	for _, v := range image.References() {
+		args = append(args, "-t", v)
+	}
+
+	return append(args, buildPath)
+}
+
+// buildahArgs returns arguments of buildah bud command for a given image. Flags are the same as docker ones, except for pull
+func buildahArgs(image Image, buildPath string) (args []string) {
+	args = dockerArgs(image, buildPath)
+	args[0] = "bud"
+	for i, v := range args {
+		if v == "--pull" {
+			args[i] = "--pull-always"
+		}
+	}
+
+	return
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBuilder is an in-memory backend, it records builds instead of running them
+type fakeBuilder struct {
+	mutex sync.Mutex
+
+	// images that must fail
+	failing map[string]bool
+
+	// how long every build takes
+	delay map[string]time.Duration
+
+	// images in order they were built
+	built []string
+
+	running     int
+	maxParallel int
+
+	// number of times push of the image fails before it succeeds
+	pushFailures map[string]int
+
+	// images in order they were pushed
+	pushed []string
+
+	// tags that were added to existing images
+	tagged []string
+}
+
+func (b *fakeBuilder) Build(image Image, buildPath string, output io.Writer) (id string, err error) {
+	b.mutex.Lock()
+	b.running++
+	if b.running > b.maxParallel {
+		b.maxParallel = b.running
+	}
+	b.mutex.Unlock()
+
+	_, _ = fmt.Fprintf(output, "building %v\n", image.ContainerName)
+	time.Sleep(b.delay[image.ContainerName])
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.running--
+
+	if b.failing[image.ContainerName] {
+		return "", fmt.Errorf("%v failed", image.ContainerName)
+	}
+
+	b.built = append(b.built, image.ContainerName)
+	return "sha256:" + image.ContainerName, nil
+}
+
+func (b *fakeBuilder) Push(image Image, output io.Writer) (digest string, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	_, _ = fmt.Fprintf(output, "pushing %v\n", image.ContainerName)
+	if b.pushFailures[image.ContainerName] > 0 {
+		b.pushFailures[image.ContainerName]--
+		return "", fmt.Errorf("registry is not available")
+	}
+
+	b.pushed = append(b.pushed, image.ContainerName)
+	return "sha256:pushed-" + image.ContainerName, nil
+}
+
+func (b *fakeBuilder) Tag(image Image, output io.Writer) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	_, _ = fmt.Fprintf(output, "tagging %v\n", image.ContainerName)
+	b.tagged = append(b.tagged, image.Tags...)
+	return nil
+}
+
+// useFakeBuilder registers given backend under "fake" name, and returns function that unregisters it
+func useFakeBuilder(backend *fakeBuilder) func() {
+	builders["fake"] = func() (Builder, error) {
+		return backend, nil
+	}
+
+	return func() {
+		delete(builders, "fake")
+	}
+}
+
+// oneRootConfig returns configuration where image1 and image3 depend on image2
+func oneRootConfig() BuildConfiguration {
+	return BuildConfiguration{
+		Images:  []Image{{ContainerName: "image1", Dockerpath: "./resources/setup_oneroot/Image1"}, {ContainerName: "image2", Dockerpath: "./resources/setup_oneroot/Image2"}, {ContainerName: "image3", Dockerpath: "./resources/setup_oneroot/Image3"}},
+		Builder: "fake",
+	}
+}
+
+func TestBuildImages_FakeBuilder(t *testing.T) {
+	backend := &fakeBuilder{}
+	defer useFakeBuilder(backend)()
+
+	config := oneRootConfig()
+	config.Threads = 4
+	summary, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, summary.Built, 3)
+	require.Equal(t, "image2", backend.built[0])
+	require.Equal(t, "sha256:image2", summary.Built[0].ImageID)
+	require.Equal(t, "building image2\n", summary.Built[0].Log)
+}
+
+func TestBuildImages_Streaming(t *testing.T) {
+	// images are independent, so image1 and image2 go first with two threads, and slow image3 finishes last
+	backend := &fakeBuilder{delay: map[string]time.Duration{"image3": 300 * time.Millisecond}}
+	defer useFakeBuilder(backend)()
+
+	config := BuildConfiguration{
+		Images:  []Image{{ContainerName: "image1", Dockerpath: "./resources/setup_onedep/Image1"}, {ContainerName: "image2", Dockerpath: "./resources/setup_onedep/Image2"}, {ContainerName: "image3", Dockerpath: "./resources/setup_onedep/Image3"}},
+		Threads: 2,
+		Builder: "fake",
+	}
+
+	_, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Equal(t, []string{"image1", "image2", "image3"}, backend.built)
+}
+
+func TestBuildImages_ThreadsLimit(t *testing.T) {
+	backend := &fakeBuilder{delay: map[string]time.Duration{"image1": 50 * time.Millisecond, "image2": 50 * time.Millisecond, "image3": 50 * time.Millisecond}}
+	defer useFakeBuilder(backend)()
+
+	config := BuildConfiguration{
+		Images:  []Image{{ContainerName: "image1", Dockerpath: "./resources/setup_nodeps/Image1"}, {ContainerName: "image2", Dockerpath: "./resources/setup_nodeps/Image2"}, {ContainerName: "image3", Dockerpath: "./resources/setup_nodeps/Image3"}},
+		Threads: 2,
+		Builder: "fake",
+	}
+
+	_, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Equal(t, 2, backend.maxParallel)
+}
+
+func TestBuildImages_Failure(t *testing.T) {
+	backend := &fakeBuilder{failing: map[string]bool{"image2": true}}
+	defer useFakeBuilder(backend)()
+
+	summary, err := BuildImages(oneRootConfig())
+	require.Error(t, err)
+	require.Len(t, summary.Failed, 1)
+	require.Empty(t, backend.built)
+}
+
+func TestBuildImages_PerImageBuilder(t *testing.T) {
+	backend := &fakeBuilder{}
+	defer useFakeBuilder(backend)()
+
+	config := oneRootConfig()
+	config.Builder = "unknown"
+	for i := range config.Images {
+		config.Images[i].Builder = "fake"
+	}
+
+	_, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, backend.built, 3)
+
+	config.Images[0].Builder = "unknown"
+	_, err = BuildImages(config)
+	require.Error(t, err)
+}
+
+func Test_buildahArgs(t *testing.T) {
+	image := Image{ContainerName: "a", Dockerpath: "/src/a", Pull: true}
+	require.Equal(t, []string{"bud", "--pull-always", "-t", "a", "/src/a"}, buildahArgs(image, "/src/a"))
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	This function builds images of the configuration, i.e. krane build -f build.yaml org/api
+*/
+func buildCommand(flags *flag.FlagSet) func(args []string) error {
+	var dryRun bool
+	var configuration configurationFlags
+	var execution executionFlags
+
+	configuration.register(flags)
+	execution.register(flags)
+	flags.BoolVar(&dryRun, "d", false, "Don't run docker, only print the build plan")
+
+	return func(targets []string) error {
+		buildConfiguration, err := configuration.load(targets)
+		if err != nil {
+			return err
+		}
+
+		err = execution.apply(&buildConfiguration)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			plan, err := makePlan(buildConfiguration)
+			if err != nil {
+				return err
+			}
+
+			plan.Describe(os.Stdout)
+			return nil
+		}
+
+		return printOutcome(BuildImages(buildConfiguration))
+	}
+}
+
+/*
+	This function writes fully resolved build plan as JSON, i.e. krane plan -f build.yaml -o plan.json
+*/
+func planCommand(flags *flag.FlagSet) func(args []string) error {
+	var output string
+	var configuration configurationFlags
+
+	configuration.register(flags)
+	flags.StringVar(&output, "o", "", "File to write plan to, instead of stdout")
+
+	return func(targets []string) error {
+		config, err := configuration.load(targets)
+		if err != nil {
+			return err
+		}
+
+		plan, err := makePlan(config)
+		if err != nil {
+			return err
+		}
+
+		if len(output) == 0 {
+			return plan.Write(os.Stdout)
+		}
+
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return plan.Write(f)
+	}
+}
+
+/*
+	This function builds images exactly as given plan says, i.e. krane apply plan.json
+*/
+func applyCommand(flags *flag.FlagSet) func(args []string) error {
+	var execution executionFlags
+
+	execution.register(flags)
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("exactly one plan file must be specified")
+		}
+
+		plan, err := loadPlan(args[0])
+		if err != nil {
+			return err
+		}
+
+		var config BuildConfiguration
+		err = execution.apply(&config)
+		if err != nil {
+			return err
+		}
+
+		return printOutcome(applyPlan(plan, config))
+	}
+}
+
+/*
+	This function renders dependency graph of the configuration, i.e. krane graph -f build.yaml -format mermaid
+*/
+func graphCommand(flags *flag.FlagSet) func(args []string) error {
+	var files fileFlags
+	var format string
+	var external bool
+	var output string
+	buildArgs := make(keyValueFlag)
+
+	files.register(flags)
+	flags.StringVar(&format, "format", "dot", "Graph format: dot, mermaid or json")
+	flags.BoolVar(&external, "external", false, "Show base images that aren't built by krane")
+	flags.StringVar(&output, "o", "", "File to write graph to, instead of stdout")
+	flags.Var(buildArgs, "build-arg", "Build arg KEY=VALUE passed to every image, can be repeated")
+
+	return func(args []string) error {
+		err := noArguments(args)
+		if err != nil {
+			return err
+		}
+
+		render, has := graphFormats[format]
+		if !has {
+			return fmt.Errorf("unknown graph format [%v]", format)
+		}
+
+		config, err := files.load()
+		if err != nil {
+			return err
+		}
+
+		if len(buildArgs) > 0 {
+			config.BuildArgs = buildArgs
+		}
+
+		graph, err := buildGraph(config, external)
+		if err != nil {
+			return err
+		}
+
+		rendered, err := render(graph)
+		if err != nil {
+			return err
+		}
+
+		if len(output) > 0 {
+			return ioutil.WriteFile(output, []byte(rendered), 0644)
+		}
+
+		fmt.Print(rendered)
+		return nil
+	}
+}
+
+/*
+	This function strictly validates configuration, and prints every problem found, i.e. krane validate -f build.yaml
+*/
+func validateCommand(flags *flag.FlagSet) func(args []string) error {
+	var files fileFlags
+
+	files.register(flags)
+
+	return func(args []string) error {
+		err := noArguments(args)
+		if err != nil {
+			return err
+		}
+
+		names := files.names()
+		if len(names) == 0 {
+			return fmt.Errorf("-f or %v must be specified", envConfig)
+		}
+
+		images, err := ValidateFiles(names, files.vars)
+		if errs, ok := err.(ConfigErrors); ok {
+			return fmt.Errorf("%v\n%v problems found", errs.Error(), len(errs))
+		} else if err != nil {
+			return err
+		}
+
+		fmt.Printf("Configuration is valid, %v images\n", images)
+		return nil
+	}
+}
+
+/*
+	This function finds Dockerfiles under the given folder, and prints configuration that builds them, i.e. krane discover -o build.yaml ./services.
+	Existing configuration file is updated, images that are already there are left as they are
+*/
+func discoverCommand(flags *flag.FlagSet) func(args []string) error {
+	var name string
+	var output string
+	vars := make(keyValueFlag)
+
+	flags.StringVar(&name, "name", defaultDiscoverName, "Template of image names, {{dir}} is the folder name and {{path}} is the path relative to the root")
+	flags.StringVar(&output, "o", "", "Configuration file to update, instead of printing it to stdout")
+	flags.Var(vars, "var", "Variable KEY=VALUE used for ${KEY} within configuration, can be repeated")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("exactly one root folder must be specified")
+		}
+
+		images, err := discoverImages(args[0], name)
+		if err != nil {
+			return err
+		}
+
+		var conf []byte
+		if len(output) > 0 {
+			conf, err = ioutil.ReadFile(output)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		// paths within configuration file are relative to it
+		dir := "."
+		if len(output) > 0 {
+			dir = filepath.Dir(output)
+		}
+
+		dotEnv, err := readDotEnv(filepath.Join(dir, dotEnvFile))
+		if err != nil {
+			return err
+		}
+
+		conf, added, err := updateConfiguration(conf, images, dir, variables{overrides: vars, dotEnv: dotEnv})
+		if err != nil {
+			return err
+		}
+
+		// resulting configuration has to be valid, and its graph is shown
+		config, err := parseBytes(conf, variables{overrides: vars, dotEnv: dotEnv}, dir)
+		if err != nil {
+			return err
+		}
+
+		for i := range config.Images {
+			config.Images[i] = relativeTo(dir, config.Images[i])
+		}
+
+		graph, err := buildGraph(config, false)
+		if err != nil {
+			return err
+		}
+
+		// configuration itself goes to stdout, if there's no file
+		info := os.Stdout
+		if len(output) == 0 {
+			info = os.Stderr
+		}
+
+		_, _ = fmt.Fprintf(info, "Found %v Dockerfiles, added %v images\n", len(images), len(added))
+		for i, layer := range graph.Layers {
+			_, _ = fmt.Fprintf(info, "layer %v: %v\n", i, strings.Join(layer, ", "))
+		}
+
+		if len(output) > 0 {
+			return ioutil.WriteFile(output, conf, 0644)
+		}
+
+		_, err = os.Stdout.Write(conf)
+		return err
+	}
+}
+
+/*
+	This function removes incremental build state and build logs, i.e. krane clean -f build.yaml.
+	Locations are taken from configuration, if there's one, and can be overridden with flags.
+	Only log files of images of the configuration are removed, the rest of the log folder is left as is
+*/
+func cleanCommand(flags *flag.FlagSet) func(args []string) error {
+	var files fileFlags
+	var stateFile string
+	var logDir string
+
+	files.register(flags)
+	flags.StringVar(&stateFile, "state", "", "State file of incremental builds to remove, "+DefaultStateFile+" if not set")
+	flags.StringVar(&logDir, "log-dir", "", "Folder with build logs to remove, only logs of images of the configuration are removed")
+
+	return func(args []string) error {
+		err := noArguments(args)
+		if err != nil {
+			return err
+		}
+
+		var config BuildConfiguration
+		if len(files.names()) > 0 {
+			config, err = files.load()
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(stateFile) > 0 {
+			config.StateFile = stateFile
+		}
+
+		if len(logDir) > 0 {
+			config.LogDir = logDir
+		}
+
+		// log folder can be shared with anything else, so only logs of images of the configuration are removed
+		if len(config.LogDir) > 0 && len(config.Images) == 0 {
+			return fmt.Errorf("build logs can be removed only along with -f or %v, that tell which images they belong to", envConfig)
+		}
+
+		err = os.Remove(config.StatePath())
+		if err == nil {
+			fmt.Printf("Removed %v\n", config.StatePath())
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if len(config.LogDir) == 0 {
+			return nil
+		}
+
+		for _, image := range config.Images {
+			name := logFileName(config.LogDir, image.ContainerName)
+			err = os.Remove(name)
+			if err == nil {
+				fmt.Printf("Removed %v\n", name)
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
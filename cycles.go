@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CycleError describes every dependency cycle found in the graph
+type CycleError struct {
+	Cycles [][]string
+
+	// origins of every edge: image -> dependency -> Dockerfile location
+	Origins map[string]map[string]string
+}
+
+func (e CycleError) Error() string {
+	var b strings.Builder
+	b.WriteString("dependency cycle detected:")
+	for _, cycle := range e.Cycles {
+		b.WriteString("\n  " + strings.Join(cycle, " -> "))
+		for i := 0; i < len(cycle)-1; i++ {
+			b.WriteString(fmt.Sprintf("\n    %v -> %v at %v", cycle[i], cycle[i+1], e.Origins[cycle[i]][cycle[i+1]]))
+		}
+	}
+
+	return b.String()
+}
+
+/*
+	This function checks internal dependencies for cycles, including images that depend on themselves.
+	If there are any, CycleError is returned, with Dockerfile locations of every edge of every cycle
+*/
+func checkCycles(config BuildConfiguration, inDeps Dependencies) error {
+	cycles := findCycles(inDeps)
+	if len(cycles) == 0 {
+		return nil
+	}
+
+	origins, err := dependencyOrigins(config)
+	if err != nil {
+		return err
+	}
+
+	return CycleError{Cycles: cycles, Origins: origins}
+}
+
+/*
+	This function finds cycles in the graph, with depth-first search. Every cycle is reported as a path that starts and ends with the same image
+*/
+func findCycles(deps Dependencies) (cycles [][]string) {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+
+	state := make(map[string]int)
+	var stack []string
+	seen := make(map[string]bool)
+
+	var visit func(current string)
+	visit = func(current string) {
+		state[current] = inProgress
+		stack = append(stack, current)
+
+		children := append([]string{}, deps[current]...)
+		sort.Strings(children)
+		for _, child := range children {
+			switch state[child] {
+			case unvisited:
+				visit(child)
+			case inProgress:
+				// child is on the stack, so everything from it to the current image is a cycle
+				start := len(stack) - 1
+				for stack[start] != child {
+					start--
+				}
+
+				cycle := append(append([]string{}, stack[start:]...), child)
+				if key := cycleKey(cycle); !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[current] = done
+	}
+
+	var names []string
+	for k := range deps {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+	for _, v := range names {
+		if state[v] == unvisited {
+			visit(v)
+		}
+	}
+
+	return
+}
+
+// cycleKey returns the same key for every rotation of the cycle
+func cycleKey(cycle []string) string {
+	nodes := cycle[:len(cycle)-1]
+	smallest := 0
+	for i, v := range nodes {
+		if v < nodes[smallest] {
+			smallest = i
+		}
+	}
+
+	return strings.Join(append(append([]string{}, nodes[smallest:]...), nodes[:smallest]...), " ")
+}
+
+/*
+	This function returns Dockerfile location, as file:line, of every dependency of every image
+*/
+func dependencyOrigins(config BuildConfiguration) (origins map[string]map[string]string, err error) {
+	namesMap, err := config.NamesMap()
+	if err != nil {
+		return
+	}
+
+	aliases, err := config.Aliases()
+	if err != nil {
+		return
+	}
+
+	origins = make(map[string]map[string]string)
+	for k, v := range namesMap {
+		var dockerfile string
+		dockerfile, err = v.Dockerfile()
+		if err != nil {
+			return
+		}
+
+		var references []Reference
+		references, err = findDockerReferences(dockerfile, v.EffectiveBuildArgs(config.BuildArgs))
+		if err != nil {
+			return
+		}
+
+		origins[k] = make(map[string]string)
+		for _, reference := range references {
+			name := reference.Name
+			if primary, has := aliases[name]; has {
+				name = primary
+			}
+
+			origins[k][name] = fmt.Sprintf("%v:%v", v.ResolvedDockerfile(), reference.Line)
+		}
+	}
+
+	return
+}
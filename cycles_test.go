@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_findCycles(t *testing.T) {
+	tests := []struct {
+		name string
+		deps Dependencies
+		want [][]string
+	}{
+		{"test_0", Dependencies{"a": {}, "b": {"a"}}, nil},
+		{"test_1", Dependencies{"a": {"a"}}, [][]string{{"a", "a"}}},
+		{"test_2", Dependencies{"a": {"b"}, "b": {"c"}, "c": {"a"}, "d": {"a"}}, [][]string{{"a", "b", "c", "a"}}},
+		{"test_3", Dependencies{"a": {"b"}, "b": {"a"}, "c": {"d"}, "d": {"c"}}, [][]string{{"a", "b", "a"}, {"c", "d", "c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, findCycles(tt.deps))
+		})
+	}
+}
+
+func Test_buildExecutableMap_Cycle(t *testing.T) {
+	config := BuildConfiguration{
+		Images: []Image{{ContainerName: "image1", Dockerpath: "./resources/setup_cycle/Image1"}, {ContainerName: "image2", Dockerpath: "./resources/setup_cycle/Image2"}, {ContainerName: "image3", Dockerpath: "./resources/setup_cycle/Image3"}},
+	}
+
+	_, err := buildExecutableMap(config)
+	require.EqualError(t, err, "dependency cycle detected:\n"+
+		"  image1:latest -> image2:latest -> image1:latest\n"+
+		"    image1:latest -> image2:latest at resources/setup_cycle/Image1/Dockerfile:1\n"+
+		"    image2:latest -> image1:latest at resources/setup_cycle/Image2/Dockerfile:5")
+}
+
+func TestBuildImages_SelfDependency(t *testing.T) {
+	backend := &fakeBuilder{}
+	defer useFakeBuilder(backend)()
+
+	config := BuildConfiguration{
+		Images:  []Image{{ContainerName: "image1", Dockerpath: "./resources/setup_selfdep/Image1"}},
+		Builder: "fake",
+	}
+
+	_, err := BuildImages(config)
+	require.EqualError(t, err, "dependency cycle detected:\n"+
+		"  image1:latest -> image1:latest\n"+
+		"    image1:latest -> image1:latest at resources/setup_selfdep/Image1/Dockerfile:5")
+	require.Empty(t, backend.built)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// default template of names of discovered images
+const defaultDiscoverName = "{{dir}}:latest"
+
+// folders that never contain images of their own
+var skippedFolders = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+}
+
+/*
+	This function walks the tree and returns folders that have Dockerfile in them, relative to the root and sorted.
+	Hidden folders, node_modules and vendor are skipped
+*/
+func findDockerfiles(root string) (folders []string, err error) {
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if path != root && (strings.HasPrefix(info.Name(), ".") || skippedFolders[info.Name()]) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if info.Name() != "Dockerfile" {
+			return nil
+		}
+
+		folder, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+
+		folders = append(folders, folder)
+		return nil
+	})
+
+	sort.Strings(folders)
+	return
+}
+
+/*
+	This function creates image for every Dockerfile found under the root. Names come from the template,
+	{{dir}} is the name of the folder and {{path}} is the path to it relative to the root, with / replaced by -
+*/
+func discoverImages(root string, nameTemplate string) (images []Image, err error) {
+	absolute, err := filepath.Abs(root)
+	if err != nil {
+		return
+	}
+
+	var folder string
+	t, err := template.New("name").Option("missingkey=error").Funcs(template.FuncMap{
+		"dir": func() string {
+			if folder == "." {
+				return strings.ToLower(filepath.Base(absolute))
+			}
+
+			return strings.ToLower(filepath.Base(folder))
+		},
+		"path": func() string {
+			if folder == "." {
+				return strings.ToLower(filepath.Base(absolute))
+			}
+
+			return strings.ToLower(strings.ReplaceAll(filepath.ToSlash(folder), "/", "-"))
+		},
+	}).Parse(nameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("wrong name template [%v]: %v", nameTemplate, err)
+	}
+
+	folders, err := findDockerfiles(root)
+	if err != nil {
+		return
+	}
+
+	// every folder has to get its own name
+	names := make(map[string]string)
+	for _, folder = range folders {
+		var name bytes.Buffer
+		err = t.Execute(&name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("can't render name of [%v]: %v", folder, err)
+		}
+
+		dockerpath := filepath.Join(root, folder)
+		if other, has := names[normalizeName(name.String())]; has {
+			return nil, fmt.Errorf("both %v and %v are named [%v], use {{path}} in name template", other, dockerpath, name.String())
+		}
+
+		names[normalizeName(name.String())] = dockerpath
+		images = append(images, Image{ContainerName: name.String(), Dockerpath: dockerpath, Folders: []string{}})
+	}
+
+	return
+}
+
+/*
+	This function adds discovered images to the existing configuration, and returns updated YAML.
+	Images whose dockerpath is already present are left as they are, along with everything else written by hand.
+	Dockerpaths are compared once variables are interpolated, relative ones are resolved against dir, the folder of the configuration.
+	Added images get dockerpaths relative to dir as well. Configuration can be empty, new one is created then.
+	Names of added images are returned as well
+*/
+func updateConfiguration(conf []byte, images []Image, dir string, vars variables) (result []byte, added []string, err error) {
+	var document yaml.Node
+	err = yaml.Unmarshal(conf, &document)
+	if err != nil {
+		return
+	}
+
+	if len(document.Content) == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, newConfigError(root, "configuration must be a map")
+	}
+
+	var build *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "build" {
+			build = root.Content[i+1]
+		}
+	}
+
+	if build == nil {
+		build = &yaml.Node{Kind: yaml.SequenceNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "build"}, build)
+	} else if build.Kind != yaml.SequenceNode {
+		return nil, nil, newConfigError(build, "build must be a list of images")
+	}
+
+	absoluteDir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+
+	// folders that are built already. document itself is written back as is, so variables are interpolated within a copy
+	var interpolated yaml.Node
+	err = yaml.Unmarshal(conf, &interpolated)
+	if err != nil {
+		return
+	}
+
+	if errs := interpolateDocument(&interpolated, vars); len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	var existingImages struct {
+		Images []Image `yaml:"build"`
+	}
+
+	if len(interpolated.Content) > 0 {
+		_ = interpolated.Content[0].Decode(&existingImages)
+	}
+
+	existing := make(map[string]bool)
+	for _, image := range existingImages.Images {
+		if len(image.Dockerpath) > 0 {
+			existing[joinPath(absoluteDir, image.Dockerpath)] = true
+		}
+	}
+
+	for _, image := range images {
+		dockerpath, err := filepath.Abs(image.Dockerpath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if existing[dockerpath] {
+			continue
+		}
+
+		// path is relative to the configuration, unless there's no way to get there
+		if relative, err := filepath.Rel(absoluteDir, dockerpath); err == nil {
+			dockerpath = relative
+		}
+
+		build.Content = append(build.Content, &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "containerName"},
+			{Kind: yaml.ScalarNode, Value: image.ContainerName},
+			{Kind: yaml.ScalarNode, Value: "dockerpath"},
+			{Kind: yaml.ScalarNode, Value: filepath.ToSlash(dockerpath)},
+		}})
+		added = append(added, image.ContainerName)
+	}
+
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&document)
+	if err == nil {
+		err = encoder.Close()
+	}
+
+	return b.Bytes(), added, err
+}
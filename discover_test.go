@@ -0,0 +1,194 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_discoverImages(t *testing.T) {
+	root := writeConfigs(t, map[string]string{
+		"api/Dockerfile":                   "FROM org/base",
+		"base/Dockerfile":                  "FROM alpine",
+		"tools/api/Dockerfile":             "FROM alpine",
+		"web/node_modules/pkg/Dockerfile":  "FROM alpine",
+		".github/actions/check/Dockerfile": "FROM alpine",
+		"docs/README.md":                   "nothing to build",
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+		wantErr  bool
+	}{
+		{"test_0", "org/{{path}}", []string{"org/api", "org/base", "org/tools-api"}, false},
+		{"test_1", "registry:5000/{{path}}:dev", []string{"registry:5000/api:dev", "registry:5000/base:dev", "registry:5000/tools-api:dev"}, false},
+		// both api folders are named the same
+		{"test_2", "org/{{dir}}", nil, true},
+		{"test_3", "org/{{.Missing}", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := discoverImages(root, tt.template)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			var names []string
+			for _, v := range images {
+				names = append(names, v.ContainerName)
+			}
+
+			require.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func Test_updateConfiguration(t *testing.T) {
+	images := []Image{
+		{ContainerName: "org/api", Dockerpath: "services/api"},
+		{ContainerName: "org/base", Dockerpath: "services/base"},
+	}
+
+	tests := []struct {
+		name      string
+		conf      string
+		want      string
+		wantAdded []string
+		wantErr   bool
+	}{
+		{"test_0", "", `build:
+  - containerName: org/api
+    dockerpath: services/api
+  - containerName: org/base
+    dockerpath: services/base
+`, []string{"org/api", "org/base"}, false},
+		{"test_1", `threads: 2
+# written by hand
+build:
+  - containerName: org/custom-base
+    dockerpath: ./services/base # not discovered
+    noCache: true
+`, `threads: 2
+# written by hand
+build:
+  - containerName: org/custom-base
+    dockerpath: ./services/base # not discovered
+    noCache: true
+  - containerName: org/api
+    dockerpath: services/api
+`, []string{"org/api"}, false},
+		{"test_2", "threads: 2\n", `threads: 2
+build:
+  - containerName: org/api
+    dockerpath: services/api
+  - containerName: org/base
+    dockerpath: services/base
+`, []string{"org/api", "org/base"}, false},
+		{"test_3", "build: yes\n", "", nil, true},
+		{"test_4", "- a\n- b\n", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, added, err := updateConfiguration([]byte(tt.conf), images, ".", variables{})
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(got))
+			require.Equal(t, tt.wantAdded, added)
+		})
+	}
+}
+
+func Test_updateConfiguration_Paths(t *testing.T) {
+	images := []Image{
+		{ContainerName: "org/api", Dockerpath: "services/api"},
+		{ContainerName: "org/base", Dockerpath: "services/base"},
+	}
+
+	base, err := filepath.Abs("services/base")
+	require.NoError(t, err)
+
+	vars := variables{overrides: map[string]string{"SRC": "services"}}
+	tests := []struct {
+		name      string
+		conf      string
+		dir       string
+		wantAdded []string
+		wantPath  string
+	}{
+		{"test_0", "build:\n  - dockerpath: ./services/base/\n", ".", []string{"org/api"}, "services/api"},
+		{"test_1", "build:\n  - dockerpath: " + base + "\n", ".", []string{"org/api"}, "services/api"},
+		{"test_2", "build:\n  - dockerpath: ${SRC}/base\n", ".", []string{"org/api"}, "services/api"},
+		// paths are relative to the configuration file
+		{"test_3", "build:\n  - dockerpath: ./base\n", "services", []string{"org/api"}, "api"},
+		{"test_4", "build:\n  - dockerpath: ./services/base\n", "deploy", []string{"org/api", "org/base"}, "../services/api"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, added, err := updateConfiguration([]byte(tt.conf), images, tt.dir, vars)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantAdded, added)
+			require.Contains(t, string(got), "dockerpath: "+tt.wantPath+"\n")
+		})
+	}
+
+	// variables that aren't set are reported
+	_, _, err = updateConfiguration([]byte("build:\n  - dockerpath: ${MISSING}/base\n"), images, ".", variables{})
+	require.Error(t, err)
+}
+
+func Test_updateConfiguration_LoadFiles(t *testing.T) {
+	root := writeConfigs(t, map[string]string{
+		"services/api/Dockerfile":  "FROM org/base",
+		"services/base/Dockerfile": "FROM alpine",
+	})
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(root))
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"test_0", "krane.yaml"},
+		{"test_1", "services/krane.yaml"},
+		{"test_2", "deploy/krane.yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := discoverImages("services", "org/{{dir}}")
+			require.NoError(t, err)
+
+			conf, _, err := updateConfiguration(nil, images, filepath.Dir(tt.output), variables{})
+			require.NoError(t, err)
+			require.NoError(t, os.MkdirAll(filepath.Dir(tt.output), 0755))
+			require.NoError(t, os.WriteFile(tt.output, conf, 0644))
+
+			// written configuration is loaded the same way, no matter where it is
+			config, err := LoadFiles([]string{tt.output}, nil)
+			require.NoError(t, err)
+			require.Len(t, config.Images, 2)
+			for _, v := range config.Images {
+				require.FileExists(t, v.ResolvedDockerfile())
+			}
+
+			_, err = buildGraph(config, false)
+			require.NoError(t, err)
+		})
+	}
+}
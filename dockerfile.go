@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Instruction is a single Dockerfile instruction, with continuation lines joined and comments stripped
+type Instruction struct {
+	// Command is upper-cased instruction keyword, i.e. FROM or COPY
+	Command string
+
+	// Flags are leading --name=value arguments of the instruction
+	Flags []Flag
+
+	// Args are remaining arguments, split by whitespace
+	Args []string
+
+	// Line is a 1-based line number where instruction starts
+	Line int
+}
+
+type Flag struct {
+	Name  string
+	Value string
+}
+
+// heredoc start, i.e. <<EOF, <<-EOF or <<"EOF", within RUN, COPY or ADD
+var heredocStart = regexp.MustCompile(`<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)`)
+
+// heredoc is a here-document body that follows an instruction, up to its delimiter
+type heredoc struct {
+	delimiter string
+
+	// <<- form allows delimiter to be indented with tabs
+	stripTabs bool
+}
+
+/*
+	This method returns values of all flags with a given name, i.e. "from" for --from=builder
+*/
+func (i Instruction) FlagValues(name string) (values []string) {
+	for _, v := range i.Flags {
+		if strings.EqualFold(v.Name, name) {
+			values = append(values, v.Value)
+		}
+	}
+
+	return
+}
+
+/*
+	This function splits Dockerfile, given as string, into instructions
+*/
+func parseDockerfile(dockerfile string) (instructions []Instruction, err error) {
+	lines := strings.Split(strings.ReplaceAll(dockerfile, "\r\n", "\n"), "\n")
+	escape := parseEscapeDirective(lines)
+
+	var current []string
+	var heredocs []heredoc
+	start := 0
+	for i, line := range lines {
+		// here-document bodies aren't instructions, i.e. "from os import path" within RUN <<EOF, they're skipped up to their delimiters
+		if len(heredocs) > 0 {
+			if heredocs[0].stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+
+			if line == heredocs[0].delimiter {
+				heredocs = heredocs[1:]
+			}
+
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+
+		// comments and empty lines are ignored, even within continuation
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if len(current) == 0 {
+			start = i + 1
+		}
+
+		// line ending with escape character continues on the next line
+		if strings.HasSuffix(trimmed, escape) {
+			current = append(current, strings.TrimSuffix(trimmed, escape))
+			continue
+		}
+
+		current = append(current, trimmed)
+		instruction := newInstruction(strings.Join(current, " "), start)
+		instructions = append(instructions, instruction)
+		heredocs = findHeredocs(instruction)
+		current = nil
+	}
+
+	if len(current) > 0 {
+		err = fmt.Errorf("unexpected end of Dockerfile: instruction started at line %v is not finished", start)
+	} else if len(heredocs) > 0 {
+		err = fmt.Errorf("unexpected end of Dockerfile: here-document [%v] is not finished", heredocs[0].delimiter)
+	}
+
+	return
+}
+
+// findHeredocs returns here-documents the instruction starts, in order their bodies follow it. Only RUN, COPY and ADD can have them
+func findHeredocs(instruction Instruction) (heredocs []heredoc) {
+	if instruction.Command != "RUN" && instruction.Command != "COPY" && instruction.Command != "ADD" {
+		return
+	}
+
+	for _, v := range instruction.Args {
+		for _, match := range heredocStart.FindAllStringSubmatch(v, -1) {
+			// quotes have to match, if there are any
+			if match[2] != match[4] {
+				continue
+			}
+
+			heredocs = append(heredocs, heredoc{delimiter: match[3], stripTabs: match[1] == "-"})
+		}
+	}
+
+	return
+}
+
+// parseEscapeDirective looks for "# escape=" parser directive, which can be only at the very top of Dockerfile
+func parseEscapeDirective(lines []string) string {
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+
+		directive := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(trimmed, "#")), "=", 2)
+		if len(directive) == 2 && strings.EqualFold(strings.TrimSpace(directive[0]), "escape") {
+			if value := strings.TrimSpace(directive[1]); len(value) > 0 {
+				return value
+			}
+		}
+	}
+
+	return "\\"
+}
+
+// newInstruction splits instruction text into command, flags and arguments
+func newInstruction(text string, line int) (instruction Instruction) {
+	fields := strings.Fields(text)
+	instruction.Command = strings.ToUpper(fields[0])
+	instruction.Line = line
+
+	fields = fields[1:]
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+		split := strings.SplitN(strings.TrimPrefix(fields[0], "--"), "=", 2)
+		flag := Flag{Name: split[0]}
+		if len(split) == 2 {
+			flag.Value = split[1]
+		}
+
+		instruction.Flags = append(instruction.Flags, flag)
+		fields = fields[1:]
+	}
+
+	instruction.Args = fields
+	return
+}
+
+/*
+	This function collects ARG instructions declared before the first FROM. These are the only ones that can be used in FROM.
+	Declared default values are replaced with buildArgs, if given
+*/
+func globalArgs(instructions []Instruction, buildArgs map[string]string) map[string]string {
+	args := make(map[string]string)
+	for _, v := range instructions {
+		if v.Command == "FROM" {
+			break
+		}
+
+		if v.Command != "ARG" {
+			continue
+		}
+
+		for _, arg := range v.Args {
+			split := strings.SplitN(arg, "=", 2)
+			name := split[0]
+			if value, has := buildArgs[name]; has {
+				args[name] = value
+			} else if len(split) == 2 {
+				args[name] = unquote(split[1])
+			} else {
+				args[name] = ""
+			}
+		}
+	}
+
+	return args
+}
+
+// unquote strips matching quotes around the value
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+/*
+	This function expands $NAME, ${NAME}, ${NAME:-default} and ${NAME:+alternative} references using given args
+*/
+func expandArgs(value string, args map[string]string) string {
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		// ${...} form
+		if value[i+1] == '{' {
+			end := strings.IndexByte(value[i:], '}')
+			if end < 0 {
+				result.WriteString(value[i:])
+				break
+			}
+
+			result.WriteString(expandExpression(value[i+2:i+end], args))
+			i += end
+			continue
+		}
+
+		// $NAME form
+		end := i + 1
+		for end < len(value) && isNameCharacter(value[end]) {
+			end++
+		}
+
+		if end == i+1 {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		result.WriteString(args[value[i+1:end]])
+		i = end - 1
+	}
+
+	return result.String()
+}
+
+// expandExpression expands contents of ${...}
+func expandExpression(expression string, args map[string]string) string {
+	if split := strings.SplitN(expression, ":-", 2); len(split) == 2 {
+		if value := args[split[0]]; len(value) > 0 {
+			return value
+		}
+
+		return split[1]
+	}
+
+	if split := strings.SplitN(expression, ":+", 2); len(split) == 2 {
+		if value := args[split[0]]; len(value) > 0 {
+			return split[1]
+		}
+
+		return ""
+	}
+
+	return args[expression]
+}
+
+func isNameCharacter(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_parseDockerfile(t *testing.T) {
+	dockerfile := "# syntax=docker/dockerfile:1\n" +
+		"from --platform=$BUILDPLATFORM golang:1.16 as builder\n" +
+		"\n" +
+		"# build everything\n" +
+		"RUN go build \\\n" +
+		"    # comments are allowed within continuation\n" +
+		"    -o /app .\n" +
+		"COPY --from=builder --chown=1000 /app /app\n"
+
+	instructions, err := parseDockerfile(dockerfile)
+	require.NoError(t, err)
+	require.Equal(t, []Instruction{
+		{Command: "FROM", Flags: []Flag{{Name: "platform", Value: "$BUILDPLATFORM"}}, Args: []string{"golang:1.16", "as", "builder"}, Line: 2},
+		{Command: "RUN", Args: []string{"go", "build", "-o", "/app", "."}, Line: 5},
+		{Command: "COPY", Flags: []Flag{{Name: "from", Value: "builder"}, {Name: "chown", Value: "1000"}}, Args: []string{"/app", "/app"}, Line: 8},
+	}, instructions)
+	require.Equal(t, []string{"builder"}, instructions[2].FlagValues("from"))
+}
+
+func Test_parseDockerfile_EscapeDirective(t *testing.T) {
+	instructions, err := parseDockerfile("# escape=`\nFROM mcr.microsoft.com/windows/servercore\nRUN dir `\n  c:\\\n")
+	require.NoError(t, err)
+	require.Len(t, instructions, 2)
+	require.Equal(t, []string{"dir", "c:\\"}, instructions[1].Args)
+}
+
+func Test_parseDockerfile_Unfinished(t *testing.T) {
+	_, err := parseDockerfile("FROM alpine\nRUN echo \\\n")
+	require.Error(t, err)
+}
+
+func Test_parseDockerfile_Heredocs(t *testing.T) {
+	dockerfile := "FROM python:3.11\n" +
+		"RUN <<EOF\n" +
+		"from os import path\n" +
+		"\n" +
+		"print(path.sep)\n" +
+		"EOF\n" +
+		"COPY <<-\"FIRST\" /first <<SECOND /second\n" +
+		"\tfrom alpine\n" +
+		"\tFIRST\n" +
+		"FROM scratch\n" +
+		"SECOND\n" +
+		"# heredoc is text of other instructions\n" +
+		"ENV GREETING=<<EOF\n" +
+		"FROM org/base\n"
+
+	instructions, err := parseDockerfile(dockerfile)
+	require.NoError(t, err)
+
+	var commands []string
+	for _, v := range instructions {
+		commands = append(commands, v.Command)
+	}
+
+	require.Equal(t, []string{"FROM", "RUN", "COPY", "ENV", "FROM"}, commands)
+	require.Equal(t, 7, instructions[2].Line)
+	require.Equal(t, []string{"org/base"}, instructions[4].Args)
+
+	_, err = parseDockerfile("FROM alpine\nRUN <<EOF\necho hello\n")
+	require.Error(t, err)
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// name of the Dockerfile within build context, when original Dockerfile is located outside of it
+const contextDockerfile = ".krane.Dockerfile"
+
+// engineBuilder builds images via Docker Engine API, without docker binary
+type engineBuilder struct {
+	client  *http.Client
+	baseURL string
+}
+
+// engineMessage is a single JSON message of the build progress stream
+type engineMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux json.RawMessage `json:"aux"`
+}
+
+/*
+	This function creates Engine API client for a given host, i.e. unix:///var/run/docker.sock or tcp://127.0.0.1:2375.
+	DOCKER_HOST is used if host is empty
+*/
+func newEngineBuilder(host string) (*engineBuilder, error) {
+	if len(host) == 0 {
+		host = os.Getenv("DOCKER_HOST")
+	}
+
+	if len(host) == 0 {
+		host = defaultDockerHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+
+		return &engineBuilder{client: &http.Client{Transport: transport}, baseURL: "http://docker"}, nil
+	case "tcp":
+		return &engineBuilder{client: &http.Client{}, baseURL: "http://" + u.Host}, nil
+	case "http", "https":
+		return &engineBuilder{client: &http.Client{}, baseURL: strings.TrimSuffix(host, "/")}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host [%v]", host)
+	}
+}
+
+func (b *engineBuilder) Build(image Image, buildPath string, output io.Writer) (id string, err error) {
+	if len(image.Secrets) > 0 || len(image.SSH) > 0 || len(image.ExtraArgs) > 0 {
+		return "", fmt.Errorf("engine builder doesn't support secrets, ssh and extraArgs")
+	}
+
+	dockerfile, external, err := contextRelativeDockerfile(image, buildPath)
+	if err != nil {
+		return
+	}
+
+	query, err := engineBuildQuery(image, dockerfile)
+	if err != nil {
+		return
+	}
+
+	// build context is streamed as tar archive, while it's being created
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(writeContext(writer, buildPath, external))
+	}()
+	defer reader.Close()
+
+	request, err := http.NewRequest(http.MethodPost, b.baseURL+"/build?"+query.Encode(), reader)
+	if err != nil {
+		return
+	}
+
+	request.Header.Set("Content-Type", "application/x-tar")
+	response, err := b.client.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", engineError(response)
+	}
+
+	return decodeStream(response.Body, output, "ID")
+}
+
+/*
+	This method pushes every tag of the image. All of them point to the same image, so they share the digest
+*/
+func (b *engineBuilder) Push(image Image, output io.Writer) (digest string, err error) {
+	for _, v := range image.References() {
+		digest, err = b.pushReference(v, output)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// pushReference pushes single reference, and returns its digest
+func (b *engineBuilder) pushReference(reference string, output io.Writer) (digest string, err error) {
+	name, tag := splitTag(reference)
+	query := url.Values{}
+	query.Set("tag", tag)
+
+	request, err := http.NewRequest(http.MethodPost, b.baseURL+"/images/"+url.PathEscape(name)+"/push?"+query.Encode(), nil)
+	if err != nil {
+		return
+	}
+
+	// daemon requires auth header, even if it's empty. credentials of the daemon itself are used then
+	request.Header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString([]byte("{}")))
+	response, err := b.client.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", engineError(response)
+	}
+
+	return decodeStream(response.Body, output, "Digest")
+}
+
+/*
+	This method adds every tag of the image to the existing image
+*/
+func (b *engineBuilder) Tag(image Image, output io.Writer) error {
+	for _, v := range image.Tags {
+		repository, tag := splitTag(v)
+		query := url.Values{}
+		query.Set("repo", repository)
+		query.Set("tag", tag)
+
+		response, err := b.client.Post(b.baseURL+"/images/"+url.PathEscape(image.ContainerName)+"/tag?"+query.Encode(), "", nil)
+		if err != nil {
+			return err
+		}
+
+		if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+			err = engineError(response)
+		}
+		_ = response.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(output, "Tagged %v\n", v)
+	}
+
+	return nil
+}
+
+func (b *engineBuilder) Label(name string, label string) (string, error) {
+	response, err := b.client.Get(b.baseURL + "/images/" + url.PathEscape(name) + "/json")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return "", nil
+	} else if response.StatusCode != http.StatusOK {
+		return "", engineError(response)
+	}
+
+	var inspected struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&inspected)
+	return inspected.Config.Labels[label], err
+}
+
+// contextRelativeDockerfile returns Dockerfile path within build context. If Dockerfile is outside of the context, its path is returned as external
+func contextRelativeDockerfile(image Image, buildPath string) (dockerfile string, external string, err error) {
+	root, err := filepath.Abs(buildPath)
+	if err != nil {
+		return
+	}
+
+	absolute, err := filepath.Abs(image.ResolvedDockerfile())
+	if err != nil {
+		return
+	}
+
+	relative, err := filepath.Rel(root, absolute)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return contextDockerfile, absolute, nil
+	}
+
+	return filepath.ToSlash(relative), "", nil
+}
+
+// engineBuildQuery converts image settings into query parameters of the build request
+func engineBuildQuery(image Image, dockerfile string) (query url.Values, err error) {
+	query = url.Values{}
+	for _, v := range image.References() {
+		query.Add("t", v)
+	}
+
+	query.Set("dockerfile", dockerfile)
+
+	if image.ForbidCache {
+		query.Set("nocache", "1")
+	}
+
+	if image.Pull {
+		query.Set("pull", "1")
+	}
+
+	if len(image.Target) > 0 {
+		query.Set("target", image.Target)
+	}
+
+	if len(image.Platform) > 0 {
+		query.Set("platform", image.Platform)
+	}
+
+	if len(image.Network) > 0 {
+		query.Set("networkmode", image.Network)
+	}
+
+	if len(image.BuildArgs) > 0 {
+		var encoded []byte
+		encoded, err = json.Marshal(image.BuildArgs)
+		if err != nil {
+			return
+		}
+
+		query.Set("buildargs", string(encoded))
+	}
+
+	if len(image.Labels) > 0 {
+		var encoded []byte
+		encoded, err = json.Marshal(image.Labels)
+		if err != nil {
+			return
+		}
+
+		query.Set("labels", string(encoded))
+	}
+
+	return
+}
+
+// writeContext writes build context as tar archive. External Dockerfile, if any, is added as contextDockerfile
+func writeContext(w io.Writer, buildPath string, external string) (err error) {
+	archive := tar.NewWriter(w)
+	filter, err := readDockerignore(buildPath)
+	if err != nil {
+		return
+	}
+
+	err = filepath.Walk(buildPath, func(current string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(buildPath, current)
+		if err != nil || relative == "." {
+			return err
+		}
+
+		relative = filepath.ToSlash(relative)
+		if excluded, skip := filter(relative); excluded {
+			if info.IsDir() && skip {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		return addToArchive(archive, current, relative, info)
+	})
+	if err != nil {
+		return
+	}
+
+	if len(external) > 0 {
+		var info os.FileInfo
+		info, err = os.Stat(external)
+		if err != nil {
+			return
+		}
+
+		err = addToArchive(archive, external, contextDockerfile, info)
+		if err != nil {
+			return
+		}
+	}
+
+	return archive.Close()
+}
+
+// addToArchive writes single file, folder or symlink into tar archive
+func addToArchive(archive *tar.Writer, file string, name string, info os.FileInfo) (err error) {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(file)
+		if err != nil {
+			return
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return
+	}
+
+	header.Name = name
+	err = archive.WriteHeader(header)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	_, err = io.Copy(archive, f)
+	return
+}
+
+// contextFilter tells if path of the build context, relative to it, is excluded. Excluded folder is skipped as a whole,
+// unless some exception of .dockerignore can bring files within it back
+type contextFilter func(relative string) (excluded bool, skip bool)
+
+/*
+	This function reads .dockerignore of the build context, and returns filter of the context. Patterns work exactly as
+	docker has them: ** matches any number of folders, later patterns take precedence, and patterns starting with ! bring excluded files back
+*/
+func readDockerignore(buildPath string) (filter contextFilter, err error) {
+	var patterns []string
+	f, err := os.Open(filepath.Join(buildPath, ".dockerignore"))
+	if err == nil {
+		patterns, err = ignorefile.ReadAll(f)
+		_ = f.Close()
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	matcher, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("wrong .dockerignore of [%v]: %v", buildPath, err)
+	}
+
+	filter = func(relative string) (excluded bool, skip bool) {
+		excluded, err := matcher.MatchesOrParentMatches(relative)
+		if err != nil || !excluded {
+			return false, false
+		}
+
+		// folder can't be skipped if there's an exception within it, i.e. !logs/keep.txt
+		if matcher.Exclusions() {
+			for _, pattern := range matcher.Patterns() {
+				if pattern.Exclusion() && strings.HasPrefix(pattern.String()+"/", relative+"/") {
+					return true, false
+				}
+			}
+		}
+
+		return true, true
+	}
+
+	return filter, nil
+}
+
+/*
+	This function splits image name into repository and tag, latest tag is assumed if there's none.
+	Digest, i.e. @sha256:..., goes first, so it's dropped. Only colon after the last slash separates the tag, the other one is registry port
+*/
+func splitTag(name string) (repository string, tag string) {
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:]
+	}
+
+	return name, "latest"
+}
+
+// decodeStream retransmits build or push progress into output, and returns given field of aux message, i.e. ID of the built image
+func decodeStream(body io.Reader, output io.Writer, field string) (value string, err error) {
+	decoder := json.NewDecoder(bufio.NewReader(body))
+	for {
+		var message engineMessage
+		err = decoder.Decode(&message)
+		if err == io.EOF {
+			return value, nil
+		}
+
+		if err != nil {
+			return
+		}
+
+		if len(message.Stream) > 0 {
+			_, _ = io.WriteString(output, message.Stream)
+		}
+
+		if len(message.Status) > 0 {
+			_, _ = fmt.Fprintln(output, message.Status)
+		}
+
+		if len(message.ErrorDetail.Message) > 0 {
+			return value, fmt.Errorf("%v", message.ErrorDetail.Message)
+		}
+
+		if len(message.Error) > 0 {
+			return value, fmt.Errorf("%v", message.Error)
+		}
+
+		if len(message.Aux) > 0 {
+			var aux map[string]interface{}
+			if json.Unmarshal(message.Aux, &aux) == nil {
+				if v, ok := aux[field].(string); ok && len(v) > 0 {
+					value = v
+				}
+			}
+		}
+	}
+}
+
+// engineError extracts error message from unsuccessful response of the daemon
+func engineError(response *http.Response) error {
+	var message struct {
+		Message string `json:"message"`
+	}
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if json.Unmarshal(body, &message) == nil && len(message.Message) > 0 {
+		return fmt.Errorf("docker daemon returned %v: %v", response.StatusCode, message.Message)
+	}
+
+	return fmt.Errorf("docker daemon returned %v: %v", response.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDaemon pretends to be docker daemon. It records received build requests, and replies with given messages
+type fakeDaemon struct {
+	path     string
+	rawPath  string
+	query    map[string]string
+	files    map[string]string
+	status   int
+	messages []string
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/build" && !strings.HasSuffix(r.URL.Path, "/push") && !strings.HasSuffix(r.URL.Path, "/tag") {
+		http.NotFound(w, r)
+		return
+	}
+
+	d.path = r.URL.Path
+	d.rawPath = r.URL.EscapedPath()
+	d.query = make(map[string]string)
+	for k := range r.URL.Query() {
+		d.query[k] = r.URL.Query().Get(k)
+	}
+
+	if len(r.Header.Get("X-Registry-Auth")) > 0 {
+		d.query["auth"] = r.Header.Get("X-Registry-Auth")
+	}
+
+	d.files = make(map[string]string)
+	archive := tar.NewReader(r.Body)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		content, _ := ioutil.ReadAll(archive)
+		d.files[header.Name] = string(content)
+	}
+
+	if d.status != 0 {
+		w.WriteHeader(d.status)
+	}
+
+	for _, v := range d.messages {
+		_, _ = fmt.Fprintln(w, v)
+	}
+}
+
+func TestEngineBuilder_Build(t *testing.T) {
+	daemon := &fakeDaemon{messages: []string{
+		`{"stream":"Step 1/2 : FROM ubuntu:20.04\n"}`,
+		`{"status":"Pulling from library/ubuntu"}`,
+		`{"aux":{"ID":"sha256:0123456789"}}`,
+		`{"stream":"Successfully built 0123456789\n"}`,
+	}}
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	backend, err := newEngineBuilder(server.URL)
+	require.NoError(t, err)
+
+	image := Image{ContainerName: "org/api:1.0", Dockerpath: "./resources/setup_nodeps/Image1", BuildArgs: map[string]string{"A": "1"}, Target: "runtime", ForbidCache: true}
+	var output bytes.Buffer
+	id, err := backend.Build(image, image.BuildContext(), &output)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:0123456789", id)
+	require.Equal(t, "Step 1/2 : FROM ubuntu:20.04\nPulling from library/ubuntu\nSuccessfully built 0123456789\n", output.String())
+
+	require.Equal(t, map[string]string{"t": "org/api:1.0", "dockerfile": "Dockerfile", "buildargs": `{"A":"1"}`, "target": "runtime", "nocache": "1"}, daemon.query)
+	require.Contains(t, daemon.files["Dockerfile"], "FROM ubuntu:20.04")
+}
+
+func TestEngineBuilder_ExternalDockerfile(t *testing.T) {
+	daemon := &fakeDaemon{}
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	backend, err := newEngineBuilder(server.URL)
+	require.NoError(t, err)
+
+	context := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(path.Join(context, "app.txt"), []byte("app"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(context, "secret.txt"), []byte("secret"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(context, ".dockerignore"), []byte("# comment\nsecret.txt\n"), 0644))
+
+	image := Image{ContainerName: "org/api", Dockerpath: "./resources/setup_nodeps/Image1", Context: context}
+	_, err = backend.Build(image, image.BuildContext(), ioutil.Discard)
+	require.NoError(t, err)
+
+	require.Equal(t, contextDockerfile, daemon.query["dockerfile"])
+	require.Contains(t, daemon.files[contextDockerfile], "FROM ubuntu:20.04")
+	require.Equal(t, "app", daemon.files["app.txt"])
+	require.NotContains(t, daemon.files, "secret.txt")
+}
+
+func TestEngineBuilder_ErrorDetail(t *testing.T) {
+	daemon := &fakeDaemon{messages: []string{
+		`{"stream":"Step 1/2 : RUN exit 1\n"}`,
+		`{"errorDetail":{"code":1,"message":"The command '/bin/sh -c exit 1' returned a non-zero code: 1"},"error":"The command '/bin/sh -c exit 1' returned a non-zero code: 1"}`,
+	}}
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	backend, err := newEngineBuilder(server.URL)
+	require.NoError(t, err)
+
+	image := Image{ContainerName: "org/api", Dockerpath: "./resources/setup_nodeps/Image1"}
+	_, err = backend.Build(image, image.BuildContext(), ioutil.Discard)
+	require.EqualError(t, err, "The command '/bin/sh -c exit 1' returned a non-zero code: 1")
+}
+
+func TestEngineBuilder_HttpError(t *testing.T) {
+	message, _ := json.Marshal(map[string]string{"message": "pull access denied"})
+	daemon := &fakeDaemon{status: http.StatusInternalServerError, messages: []string{string(message)}}
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	backend, err := newEngineBuilder(server.URL)
+	require.NoError(t, err)
+
+	image := Image{ContainerName: "org/api", Dockerpath: "./resources/setup_nodeps/Image1"}
+	_, err = backend.Build(image, image.BuildContext(), ioutil.Discard)
+	require.EqualError(t, err, "docker daemon returned 500: pull access denied")
+}
+
+func TestEngineBuilder_UnixSocket(t *testing.T) {
+	socket := path.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	daemon := &fakeDaemon{messages: []string{`{"aux":{"ID":"sha256:abc"}}`}}
+	server := httptest.NewUnstartedServer(daemon)
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	backend, err := newEngineBuilder("unix://" + socket)
+	require.NoError(t, err)
+
+	image := Image{ContainerName: "org/api", Dockerpath: "./resources/setup_nodeps/Image1"}
+	id, err := backend.Build(image, image.BuildContext(), ioutil.Discard)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:abc", id)
+}
+
+func TestEngineBuilder_Unsupported(t *testing.T) {
+	_, err := newEngineBuilder("ssh://user@host")
+	require.Error(t, err)
+
+	backend, err := newEngineBuilder("tcp://127.0.0.1:2375")
+	require.NoError(t, err)
+
+	_, err = backend.Build(Image{ContainerName: "a", SSH: []string{"default"}}, ".", ioutil.Discard)
+	require.Error(t, err)
+}
+
+func TestEngineBuilder_Push(t *testing.T) {
+	daemon := &fakeDaemon{messages: []string{
+		`{"status":"The push refers to repository [localhost:5000/org/api]"}`,
+		`{"status":"Pushed","id":"0123456789ab"}`,
+		`{"status":"1.0: digest: sha256:abcdef size: 528"}`,
+		`{"aux":{"Tag":"1.0","Digest":"sha256:abcdef","Size":528}}`,
+	}}
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	backend, err := newEngineBuilder(server.URL)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	digest, err := backend.Push(Image{ContainerName: "localhost:5000/org/api:1.0"}, &output)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:abcdef", digest)
+	require.Equal(t, "/images/localhost:5000/org/api/push", daemon.path)
+	require.Equal(t, "1.0", daemon.query["tag"])
+	require.NotEmpty(t, daemon.query["auth"])
+	require.Contains(t, output.String(), "Pushed\n")
+
+	daemon.messages = []string{`{"errorDetail":{"message":"denied: requested access to the resource is denied"},"error":"denied"}`}
+	_, err = backend.Push(Image{ContainerName: "org/api"}, &output)
+	require.EqualError(t, err, "denied: requested access to the resource is denied")
+	require.Equal(t, "/images/org/api/push", daemon.path)
+	require.Equal(t, "latest", daemon.query["tag"])
+}
+
+func TestEngineBuilder_Tag(t *testing.T) {
+	daemon := &fakeDaemon{status: http.StatusCreated}
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+
+	backend, err := newEngineBuilder(server.URL)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	err = backend.Tag(Image{ContainerName: "org/api", Tags: []string{"localhost:5000/org/api:1.0"}}, &output)
+	require.NoError(t, err)
+	require.Equal(t, "/images/org/api/tag", daemon.path)
+	require.Equal(t, "/images/org%2Fapi/tag", daemon.rawPath)
+	require.Equal(t, "localhost:5000/org/api", daemon.query["repo"])
+	require.Equal(t, "1.0", daemon.query["tag"])
+
+	daemon.status = http.StatusNotFound
+	daemon.messages = []string{`{"message":"No such image: org/api:latest"}`}
+	err = backend.Tag(Image{ContainerName: "org/api", Tags: []string{"org/api:1.0"}}, &output)
+	require.EqualError(t, err, "docker daemon returned 404: No such image: org/api:latest")
+}
+
+func Test_splitTag(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		name           string
+		image          string
+		wantRepository string
+		wantTag        string
+	}{
+		{"test_0", "org/api", "org/api", "latest"},
+		{"test_1", "org/api:1.0", "org/api", "1.0"},
+		{"test_2", "localhost:5000/org/api", "localhost:5000/org/api", "latest"},
+		{"test_3", "localhost:5000/org/api:1.0", "localhost:5000/org/api", "1.0"},
+		{"test_4", "org/api@" + digest, "org/api", "latest"},
+		{"test_5", "org/api:1.0@" + digest, "org/api", "1.0"},
+		{"test_6", "localhost:5000/org/api@" + digest, "localhost:5000/org/api", "latest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository, tag := splitTag(tt.image)
+			require.Equal(t, tt.wantRepository, repository)
+			require.Equal(t, tt.wantTag, tag)
+		})
+	}
+}
+
+func Test_readDockerignore(t *testing.T) {
+	root := writeConfigs(t, map[string]string{".dockerignore": `
+# comment
+**/*.log
+!important.log
+/build
+node_modules
+logs
+!logs/keep.txt
+`})
+
+	filter, err := readDockerignore(root)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		path         string
+		wantExcluded bool
+		wantSkip     bool
+	}{
+		{"test_0", "app.go", false, false},
+		{"test_1", "app.log", true, true},
+		{"test_2", "deep/nested/app.log", true, true},
+		{"test_3", "important.log", false, false},
+		{"test_4", "build", true, true},
+		{"test_5", "build/app", true, true},
+		{"test_6", "src/build", false, false},
+		{"test_7", "node_modules/pkg/index.js", true, true},
+		// folder has an exception within it, so it has to be walked
+		{"test_8", "logs", true, false},
+		{"test_9", "logs/keep.txt", false, false},
+		{"test_10", "logs/other.txt", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			excluded, skip := filter(tt.path)
+			require.Equal(t, tt.wantExcluded, excluded)
+			require.Equal(t, tt.wantSkip, skip)
+		})
+	}
+}
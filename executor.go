@@ -2,15 +2,15 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"log"
 	"os"
-	"os/exec"
 	"path"
-	"regexp"
-	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/otiai10/copy"
 )
@@ -22,6 +22,8 @@ type Dependencies map[string][]string
 
 type Report struct {
 	ContainerName string
+	ImageID       string
+	Digest        string
 	Log           string
 	Error         error
 	Success       bool
@@ -29,36 +31,118 @@ type Report struct {
 
 type ExecutableMap map[int][]Image
 
+// number of log lines printed for every failed image
+const failedLogLines = 20
+
+// Summary holds the outcome of every image that was considered during the build
+type Summary struct {
+	Built     []Report
+	Failed    []Report
+	Skipped   []Report
+	Unchanged []Report
+	Pushed    []Report
+}
+
+// Reference is an image referenced by Dockerfile, along with the line where it was referenced first
+type Reference struct {
+	Name string
+	Line int
+}
+
 /*
 	This function scans Dockerfile, given as string with commands, and extracts image names it depends
 */
-func findDockerDependencies(dockerfile string) (deps []string, err error) {
-	re := regexp.MustCompile(`(?im)FROM (.*?[|$| |\n])`)
-	substrings := re.FindAllStringSubmatch(dockerfile, -1)
-	for _, v := range substrings {
-		for i, dep := range v {
-			// skip first match, since it's full match
-			if i == 0 {
+func findDockerDependencies(dockerfile string, buildArgs map[string]string) (deps []string, err error) {
+	references, err := findDockerReferences(dockerfile, buildArgs)
+	for _, v := range references {
+		deps = append(deps, v.Name)
+	}
+
+	return
+}
+
+/*
+	This function scans Dockerfile, and extracts images it references, along with their lines.
+	Besides FROM, images can be referenced by COPY --from and RUN --mount=from.
+	Stages of multi-stage builds and scratch are not images, so they're not reported.
+	Global ARGs used in FROM, COPY --from and RUN --mount=from are expanded, buildArgs take precedence over their defaults
+*/
+func findDockerReferences(dockerfile string, buildArgs map[string]string) (references []Reference, err error) {
+	instructions, err := parseDockerfile(dockerfile)
+	if err != nil {
+		return
+	}
+
+	args := globalArgs(instructions, buildArgs)
+
+	stages := make(map[string]bool)
+	seen := make(map[string]bool)
+	hasFrom := false
+
+	// this closure stores image reference, unless it's a stage
+	add := func(image string, line int) {
+		if len(image) == 0 || stages[strings.ToLower(image)] || strings.EqualFold(image, "scratch") || isStageIndex(image) {
+			return
+		}
+
+		// if no tag given, assume we're on the latest tag then
+		dep := normalizeName(image)
+		if !seen[dep] {
+			seen[dep] = true
+			references = append(references, Reference{Name: dep, Line: line})
+		}
+	}
+
+	for _, v := range instructions {
+		switch v.Command {
+		case "FROM":
+			if len(v.Args) == 0 {
 				continue
 			}
 
-			dep = strings.TrimSpace(dep)
-			if !strings.Contains(dep, ":") {
-				// if no tag given, assume we're on the latest tag then
-				dep += ":latest"
-			}
+			hasFrom = true
+			add(expandArgs(v.Args[0], args), v.Line)
 
-			deps = append(deps, dep)
+			// FROM image AS name, this name can be used by later stages
+			if len(v.Args) >= 3 && strings.EqualFold(v.Args[1], "AS") {
+				stages[strings.ToLower(v.Args[2])] = true
+			}
+		case "COPY":
+			for _, from := range v.FlagValues("from") {
+				add(expandArgs(from, args), v.Line)
+			}
+		case "RUN":
+			for _, mount := range v.FlagValues("mount") {
+				add(expandArgs(mountSource(mount), args), v.Line)
+			}
 		}
 	}
 
-	if len(deps) == 0 {
+	if !hasFrom {
 		err = fmt.Errorf("no docker dependencies found. wrong Dockerfile was passed in?")
 	}
 
 	return
 }
 
+// isStageIndex checks if given reference is a numeric index of a build stage, i.e. COPY --from=0
+func isStageIndex(reference string) bool {
+	_, err := strconv.Atoi(reference)
+	return err == nil
+}
+
+// mountSource extracts from= option of RUN --mount, i.e. type=bind,from=org/tools,target=/tools
+func mountSource(mount string) string {
+	for _, option := range strings.Split(mount, ",") {
+		split := strings.SplitN(option, "=", 2)
+		if len(split) == 2 && strings.EqualFold(strings.TrimSpace(split[0]), "from") {
+			return strings.TrimSpace(split[1])
+		}
+	}
+
+	return ""
+}
+
 func scanDependencies(config BuildConfiguration) (ext, int, bwd Dependencies, err error) {
 	// create empty maps first
 	ext = make(Dependencies)
@@ -71,6 +155,12 @@ func scanDependencies(config BuildConfiguration) (ext, int, bwd Dependencies, er
 		return
 	}
 
+	// dependency can refer to any tag of the image
+	aliases, err := config.Aliases()
+	if err != nil {
+		return
+	}
+
 	// fill backward deps map at least
 	for k, _ := range namesMap {
 		bwd[k] = []string{}
@@ -85,7 +175,7 @@ func scanDependencies(config BuildConfiguration) (ext, int, bwd Dependencies, er
 			return ext, int, bwd, err
 		}
 
-		deps, err := findDockerDependencies(dockerfile)
+		deps, err := findDockerDependencies(dockerfile, v.EffectiveBuildArgs(config.BuildArgs))
 		if err != nil {
 			return ext, int, bwd, err
 		}
@@ -93,14 +183,10 @@ func scanDependencies(config BuildConfiguration) (ext, int, bwd Dependencies, er
 		// store forward deps as either external or internal dependency
 		// and update backward deps
 		for _, v := range deps {
-			// store internal backward dependency
-			if _, has := bwd[v]; has {
-				bwd[v] = append(bwd[v], k)
-			}
-
-			// store internal forward dependency
-			if _, has := namesMap[v]; has {
-				int[k] = append(int[k], v)
+			// store internal forward and backward dependency
+			if primary, has := aliases[v]; has {
+				int[k] = append(int[k], primary)
+				bwd[primary] = append(bwd[primary], k)
 			} else {
 				ext[k] = append(ext[k], v)
 			}
@@ -111,163 +197,102 @@ func scanDependencies(config BuildConfiguration) (ext, int, bwd Dependencies, er
 }
 
 /*
-	This function scans mapped map in search of highest integer value from deps
-*/
-func findDeepestLayer(mapped map[string]int, deps []string) int {
-	max := 0
-	for _, v := range deps {
-		if layer, has := mapped[v]; has && layer > max {
-			max = layer
-		}
-	}
-
-	return max
-}
-
-/*
-	This function builds topologically sorted graph of images, and returns it as map
+	This function builds topologically sorted graph of images, and returns it as map.
+	Every image goes to the layer right after the deepest layer of its parents
 */
 func buildExecutableMap(config BuildConfiguration) (result ExecutableMap, err error) {
-	namesMap, _ := config.NamesMap()
-	names := config.Names()
-
-	_, inDeps, _, err := scanDependencies(config)
+	namesMap, err := config.NamesMap()
 	if err != nil {
 		return
 	}
 
-	result = make(ExecutableMap)
-
-	currentLayer := 0
-	result[currentLayer] = []Image{}
-
-	// this structure stores information about mapping
-	mapped := make(map[string]int)
-
-	// roll through map, probably multiple times
-	// first time we add all images without deps to the root image
-	for _, k := range names {
-		deps, _ := inDeps[k]
-
-		if len(deps) == 0 {
-			v, _ := namesMap[k]
-			result[currentLayer] = append(result[currentLayer], v)
-			mapped[k] = currentLayer
-		}
+	_, inDeps, bwdDeps, err := scanDependencies(config)
+	if err != nil {
+		return
 	}
 
-	// shortcut: if all results were mapped to the first layer - just return then
-	if len(result[currentLayer]) == config.NumJobs() {
+	err = checkCycles(config, inDeps)
+	if err != nil {
 		return
 	}
 
-	currentLayer++
-
-	// now roll n^2 times through remaining elements
-	for i := 0; i < config.NumJobs()-len(result[0]); i++ {
-		for k, v := range namesMap {
-
-			// skip this layer if it was already mapped
-			if _, wasMapped := mapped[k]; wasMapped {
-				continue
-			}
-
-			// now, check if all deps were already mapped
-			// if yes - map this layer
-			// if no - skip this layer
-			deps, _ := inDeps[k]
-			allMapped := true
-			for _, dep := range deps {
-				_, wasMapped := mapped[dep]
-				allMapped = allMapped && wasMapped
-			}
+	queue, err := newScheduler(inDeps, bwdDeps)
+	if err != nil {
+		return
+	}
 
-			// if all layers were mapped we should see, which layer they were mapped to, and map to the next one
-			if allMapped {
-				deepestLayer := findDeepestLayer(mapped, deps)
-				result[deepestLayer+1] = append(result[deepestLayer+1], v)
-				mapped[k] = deepestLayer + 1
+	// parents always go before their children, so their layers are known by then
+	layers := make(map[string]int)
+	for _, k := range queue.order() {
+		layer := 0
+		for _, dep := range inDeps[k] {
+			if layers[dep]+1 > layer {
+				layer = layers[dep] + 1
 			}
 		}
+
+		layers[k] = layer
 	}
 
-	// if these two values are not equal - it means I've failed to sort the graph of dependencies. panic time
-	if len(mapped) != config.NumJobs() {
-		err = fmt.Errorf("wasn't able to sort the graph")
-		return
+	// keep images sorted by name within every layer
+	result = make(ExecutableMap)
+	result[0] = []Image{}
+	for _, k := range config.Names() {
+		result[layers[k]] = append(result[layers[k]], namesMap[k])
 	}
 
 	return
 }
 
 /*
-	This function builds Docker images
+	This function builds Docker images. Every image is dispatched as soon as all of its internal dependencies were built.
+	If config.KeepGoing is set, a failure only prevents its descendants from being built
 */
-func BuildImages(config BuildConfiguration) (err error) {
-	// make sure we use some threads
-	if config.Threads < 1 {
-		config.Threads = runtime.NumCPU()
-	}
-
-	executableMap, err := buildExecutableMap(config)
+func BuildImages(config BuildConfiguration) (summary Summary, err error) {
+	plan, err := makePlan(config)
 	if err != nil {
 		return
 	}
 
-	// reports queue. so we'll know the outcome of every build
-	requeue := make(chan Report, config.NumJobs())
+	return applyPlan(plan, config)
+}
 
-	// now, let's build some workers which will do the actual job
-	workers := make(map[int]chan Image)
-	for i := 0; i < runtime.NumCPU(); i++ {
-		workers[i] = make(chan Image)
-		go worker(workers[i], requeue)
+/*
+	This method prints outcome of the build, grouped by status
+*/
+func (s Summary) Print(l *Logger) {
+	_ = l.Println(fmt.Sprintf("Built: %v, unchanged: %v, failed: %v, skipped: %v", len(s.Built), len(s.Unchanged), len(s.Failed), len(s.Skipped)))
+	for _, v := range s.Failed {
+		_ = l.Println(fmt.Sprintf("  failed:  %v (%v)", v.ContainerName, v.Error))
 	}
 
-	// storage for the reports
-	var failed []Report
-	var succeed []Report
-
-	// dispatch all jobs one by one
-	jobsCounter := 0
-	for i := 0; i < len(executableMap); i++ {
-		dispatched := 0
-
-		// each layer is an array of images
-		layer, _ := executableMap[i]
-		for _, image := range layer {
-			workers[jobsCounter%config.Threads] <- image
-			jobsCounter += 1
-			dispatched++
-		}
+	for _, v := range s.Skipped {
+		_ = l.Println(fmt.Sprintf("  skipped: %v (%v)", v.ContainerName, v.Error))
+	}
 
-		// now, when all jobs on this layer were dispatched - wait for them to finish
-		for i := 0; i < dispatched; i++ {
-			report := <-requeue
-			if !report.Success {
-				failed = append(failed, report)
-			} else {
-				succeed = append(succeed, report)
-			}
-		}
+	for _, v := range s.Pushed {
+		_ = l.Println(fmt.Sprintf("  pushed:  %v (%v)", v.ContainerName, v.Digest))
+	}
 
-		// do something better here?
-		if len(failed) > 0 {
-			return fmt.Errorf("At least %v out of %v jobs failed", len(failed), len(config.Images))
+	// last lines of the log are usually enough to see what went wrong
+	for _, v := range s.Failed {
+		if len(v.Log) == 0 {
+			continue
 		}
-	}
 
-	// looks like we're all good
-	return
+		_ = l.Println(fmt.Sprintf("\n===== last lines of %v log =====", v.ContainerName))
+		_ = l.Print(tail(v.Log, failedLogLines))
+	}
 }
 
 /*
-	This function runs in an endless loop, building all images that come from input channel
+	This function builds all images that come from input channel, until the channel is closed.
+	Every image is built by the backend it's assigned to
 */
-func worker(input chan Image, output chan<- Report) {
-	for true {
-		image := <-input
-		builder(image, output)
+func worker(input <-chan Image, backends map[string]Builder, logDir string, output chan<- Report, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for image := range input {
+		builder(image, backends[image.Builder], logDir, output)
 	}
 }
 
@@ -305,68 +330,105 @@ func prepareFolders(root string, folders ...string) (err error) {
 	return
 }
 
-func scanAndLog(p io.ReadCloser) {
+// scanAndLog retransmits output line by line to stdout on behalf of source, and keeps a copy of every line in capture
+func scanAndLog(p io.Reader, source string, capture io.Writer) {
 	scanner := bufio.NewScanner(p)
 	for scanner.Scan() {
 		text := scanner.Text()
-		err := stdout.Println(text)
+		err := stdout.PrintlnFrom(source, text)
 		if err != nil {
 			panic(err)
 		}
+
+		_, _ = fmt.Fprintln(capture, text)
 	}
 }
 
-// builder function executes docker build
-func builder(image Image, reporting chan<- Report) {
-	var cmd *exec.Cmd
+// runLogged runs given function, and retransmits everything it writes to stdout on behalf of source, keeping a copy in capture
+func runLogged(source string, capture io.Writer, run func(output io.Writer) (string, error)) (string, error) {
+	// backend writes everything into the single pipe, so lines won't be interleaved within one image
+	pipeReader, pipeWriter := io.Pipe()
+
+	// scan/retransmit output
+	scanned := make(chan bool)
+	go func() {
+		scanAndLog(pipeReader, source, capture)
+		close(scanned)
+	}()
+
+	// run the function, and wait till all of its output is processed
+	result, err := run(pipeWriter)
+	_ = pipeWriter.Close()
+	<-scanned
+	return result, err
+}
+
+/*
+	This function returns name of the log file for a given image, safe to be used on any filesystem.
+	Separators are replaced to keep the name readable, and short hash of the original name keeps org/api and org_api apart
+*/
+func logFileName(logDir string, containerName string) string {
+	replacer := strings.NewReplacer("/", "_", ":", "_", "@", "_")
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(containerName))
+	return path.Join(logDir, fmt.Sprintf("%v-%08x.log", replacer.Replace(containerName), h.Sum32()))
+}
+
+// builder function prepares build context of the image, and builds it with a given backend
+func builder(image Image, backend Builder, logDir string, reporting chan<- Report) {
 	var err error
-	var output []byte
-	var pipeOut, pipeErr io.ReadCloser
+	var id string
+	var output bytes.Buffer
+	var capture io.Writer = &output
 
-	buildPath := image.Dockerpath
+	buildPath := image.BuildContext()
 	if len(image.Folders) > 0 {
 		// if image requires certain folders - things will happen in temporary folder
 		buildPath, err = os.MkdirTemp(os.TempDir(), fmt.Sprintf("*-build"))
 		if err != nil {
 			panic(err)
 		}
+		defer os.RemoveAll(buildPath)
 
-		// copy folders
-		err = prepareFolders(buildPath, image.Folders...)
+		// build context goes to the root of temporary folder
+		err = copy.Copy(image.BuildContext(), buildPath)
 		if err == nil {
-			// actual folder with dokerfile must be copied as well
-			err = prepareFolders(buildPath, image.Dockerpath)
+			// and folders are copied on top of it
+			err = prepareFolders(buildPath, image.Folders...)
+		}
+	}
+
+	// if requested, every image gets its own log file
+	if err == nil && len(logDir) > 0 {
+		var logFile *os.File
+		logFile, err = os.Create(logFileName(logDir, image.ContainerName))
+		if err == nil {
+			defer logFile.Close()
+			capture = io.MultiWriter(&output, logFile)
 		}
 	}
 
 	// proceed only if folders were prepared without errors
 	if err == nil {
-		if image.ForbidCache {
-			fmt.Printf("Command: docker build --no-cache -t %v %v\n", image.ContainerName, buildPath)
-			cmd = exec.Command("docker", "build", "--no-cache", "-t", image.ContainerName, buildPath)
-		} else {
-			fmt.Printf("Command B: docker build -t %v %v\n", image.ContainerName, buildPath)
-			cmd = exec.Command("docker", "build", "-t", image.ContainerName, buildPath)
+		// command goes along with the rest of the image output, so it ends up in the log as well
+		if cli, ok := backend.(commander); ok {
+			command := fmt.Sprintf("Command: %v", strings.Join(cli.Command(image, buildPath), " "))
+			_ = stdout.PrintlnFrom(image.ContainerName, command)
+			_, _ = fmt.Fprintln(capture, command)
 		}
 
-		// setup pipes
-		pipeOut, _ = cmd.StdoutPipe()
-		pipeErr, _ = cmd.StderrPipe()
-
-		// scan/retransmit stderr and stdout
-		go scanAndLog(pipeOut)
-		go scanAndLog(pipeErr)
-
-		// execute command
-		err = cmd.Run()
+		id, err = runLogged(image.ContainerName, capture, func(output io.Writer) (string, error) {
+			return backend.Build(image, buildPath, output)
+		})
 	}
 
 	// report the outcome
 	if err != nil {
-		log.Printf("Err: %v", err.Error())
-		reporting <- Report{ContainerName: image.ContainerName, Log: string(output), Error: err, Success: false}
+		// error is prefixed like the rest of the image output, so it's not mixed up with other images
+		_ = stdout.PrintlnFrom(image.ContainerName, fmt.Sprintf("Err: %v", err))
+		reporting <- Report{ContainerName: image.ContainerName, ImageID: id, Log: output.String(), Error: err, Success: false}
 	} else {
-		reporting <- Report{ContainerName: image.ContainerName, Log: string(output), Error: err, Success: true}
+		reporting <- Report{ContainerName: image.ContainerName, ImageID: id, Log: output.String(), Error: err, Success: true}
 	}
 
 	return
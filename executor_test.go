@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -15,17 +16,28 @@ func Test_findDockerDependencies(t *testing.T) {
 	tests := []struct {
 		name       string
 		dockerfile string
+		buildArgs  map[string]string
 		wantDeps   []string
 		wantErr    bool
 	}{
-		{"test_0", "FROM ubuntu:20.04\n#do something", []string{"ubuntu:20.04"}, false},
-		{"test_1", "FROM ubuntu:20.04\n#do something\nFROM alpine:latest\n#do something else", []string{"ubuntu:20.04", "alpine:latest"}, false},
-		{"test_2", "FROM ubuntu:20.04\n#do something\nFROM alpine\n#do something else", []string{"ubuntu:20.04", "alpine:latest"}, false},
-		{"test_10", "some random file content", []string{}, true},
+		{"test_0", "FROM ubuntu:20.04\n#do something", nil, []string{"ubuntu:20.04"}, false},
+		{"test_1", "FROM ubuntu:20.04\n#do something\nFROM alpine:latest\n#do something else", nil, []string{"ubuntu:20.04", "alpine:latest"}, false},
+		{"test_2", "FROM ubuntu:20.04\n#do something\nFROM alpine\n#do something else", nil, []string{"ubuntu:20.04", "alpine:latest"}, false},
+		{"test_3", "FROM golang:1.16 AS builder\nRUN go build\nFROM alpine\nCOPY --from=builder /app /app", nil, []string{"golang:1.16", "alpine:latest"}, false},
+		{"test_4", "FROM --platform=$BUILDPLATFORM golang AS build\nFROM build AS test\nfrom Build", nil, []string{"golang:latest"}, false},
+		{"test_5", "FROM scratch\nCOPY app /app", nil, []string{}, false},
+		{"test_6", "FROM \\\n  localhost:5000/org/base\nFROM org/pinned@sha256:abcdef", nil, []string{"localhost:5000/org/base:latest", "org/pinned@sha256:abcdef"}, false},
+		{"test_7", "FROM alpine\nCOPY --from=org/assets:1.2 /dist /app\nCOPY --from=0 /a /b", nil, []string{"alpine:latest", "org/assets:1.2"}, false},
+		{"test_8", "FROM alpine AS base\nRUN --mount=type=bind,from=org/tools,target=/tools --mount=type=cache,target=/root/.cache make\nRUN --mount=type=bind,from=base make", nil, []string{"alpine:latest", "org/tools:latest"}, false},
+		{"test_9", "ARG BASE=org/base:latest\nARG VERSION\nFROM ${BASE}\nFROM golang:${VERSION:-1.16}", nil, []string{"org/base:latest", "golang:1.16"}, false},
+		{"test_11", "ARG BASE=\"org/base\"\nARG VERSION\nFROM $BASE:$VERSION", map[string]string{"VERSION": "2.0", "UNUSED": "x"}, []string{"org/base:2.0"}, false},
+		{"test_12", "FROM alpine\nARG BASE=org/base\nFROM ${BASE}", nil, []string{"alpine:latest"}, false},
+		{"test_13", "ARG ASSETS=org/assets:1.2\nARG TOOLS\nFROM alpine\nCOPY --from=${ASSETS} /dist /app\nRUN --mount=type=bind,from=$TOOLS,target=/tools make", map[string]string{"TOOLS": "org/tools:2.0"}, []string{"alpine:latest", "org/assets:1.2", "org/tools:2.0"}, false},
+		{"test_10", "some random file content", nil, []string{}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotDeps, err := findDockerDependencies(tt.dockerfile)
+			gotDeps, err := findDockerDependencies(tt.dockerfile, tt.buildArgs)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("findDockerDependencies() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -59,6 +71,12 @@ func Test_scanDependencies(t *testing.T) {
 		}, Dependencies{"image1:latest": []string{"ubuntu:20.04"}, "image2:latest": []string{"ubuntu:latest"}, "image3:latest": []string{"nginx:latest"}},
 			Dependencies{"image1:latest": []string{}, "image2:latest": []string{"image1:latest"}, "image3:latest": []string{}},
 			Dependencies{"image1:latest": []string{"image2:latest"}, "image2:latest": []string{}, "image3:latest": []string{}}, false},
+
+		{"test_2", BuildConfiguration{
+			Images: []Image{{ContainerName: "image1", Dockerpath: "./resources/setup_copyfrom/Image1"}, {ContainerName: "image2", Dockerpath: "./resources/setup_copyfrom/Image2"}},
+		}, Dependencies{"image1:latest": []string{"ubuntu:20.04"}, "image2:latest": []string{"alpine:latest"}},
+			Dependencies{"image1:latest": []string{}, "image2:latest": []string{"image1:latest"}},
+			Dependencies{"image1:latest": []string{"image2:latest"}, "image2:latest": []string{}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -165,3 +183,139 @@ func Test_checkFoldersExistence(t *testing.T) {
 		})
 	}
 }
+
+func Test_tail(t *testing.T) {
+	require.Equal(t, "c\nd\n", tail("a\nb\nc\nd\n", 2))
+	require.Equal(t, "a\nb\n", tail("a\nb", 5))
+}
+
+func Test_logFileName(t *testing.T) {
+	require.Equal(t, "/tmp/logs/org_api_1.2-61b16843.log", logFileName("/tmp/logs", "org/api:1.2"))
+
+	// names that differ only by separators get different files
+	require.Equal(t, "/tmp/logs/org_api-0a484c06.log", logFileName("/tmp/logs", "org/api"))
+	require.Equal(t, "/tmp/logs/org_api-7bb36036.log", logFileName("/tmp/logs", "org_api"))
+}
+
+// fakeDocker puts fake docker binary with a given script in front of PATH, and returns function that restores PATH
+func fakeDocker(t *testing.T, script string) func() {
+	bin := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(path.Join(bin, "docker"), []byte("#!/bin/sh\n"+script), 0755))
+
+	oldPath := os.Getenv("PATH")
+	require.NoError(t, os.Setenv("PATH", bin+string(os.PathListSeparator)+oldPath))
+	return func() {
+		_ = os.Setenv("PATH", oldPath)
+	}
+}
+
+func Test_builder_CapturesLog(t *testing.T) {
+	// fake docker binary, that prints something and fails
+	defer fakeDocker(t, "echo building $3\necho oops >&2\nexit 1\n")()
+
+	console, err := ioutil.TempFile(t.TempDir(), "*.log")
+	require.NoError(t, err)
+	defer func(logger *Logger) { stdout = logger }(stdout)
+	stdout = NewLogger(console)
+
+	logDir := t.TempDir()
+	reports := make(chan Report, 1)
+	builder(Image{ContainerName: "org/api", Dockerpath: "./resources/setup_nodeps/Image1"}, cliBuilder{binary: "docker", args: dockerArgs}, logDir, reports)
+
+	report := <-reports
+	require.False(t, report.Success)
+	require.Equal(t, "Command: docker build -t org/api ./resources/setup_nodeps/Image1\nbuilding org/api\noops\n", report.Log)
+
+	// error is printed along with the rest of the image output
+	printed, err := ioutil.ReadFile(console.Name())
+	require.NoError(t, err)
+	require.Contains(t, string(printed), "[org/api] Err: exit status 1\n")
+
+	content, err := ioutil.ReadFile(logFileName(logDir, "org/api"))
+	require.NoError(t, err)
+	require.Equal(t, report.Log, string(content))
+}
+
+func TestBuildImages_Threads(t *testing.T) {
+	defer fakeDocker(t, "exit 0\n")()
+
+	config := BuildConfiguration{
+		Images:  []Image{{ContainerName: "image1", Dockerpath: "./resources/setup_oneroot/Image1"}, {ContainerName: "image2", Dockerpath: "./resources/setup_oneroot/Image2"}, {ContainerName: "image3", Dockerpath: "./resources/setup_oneroot/Image3"}},
+		Threads: runtime.NumCPU() + 4,
+	}
+
+	summary, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, summary.Built, 3)
+	require.Equal(t, "image2", summary.Built[0].ContainerName)
+}
+
+func TestBuildImages_KeepGoing(t *testing.T) {
+	// image2 fails, so image1 and image3 can't be built
+	defer fakeDocker(t, "test \"$3\" != image2\n")()
+
+	config := BuildConfiguration{
+		Images:    []Image{{ContainerName: "image1", Dockerpath: "./resources/setup_oneroot/Image1"}, {ContainerName: "image2", Dockerpath: "./resources/setup_oneroot/Image2"}, {ContainerName: "image3", Dockerpath: "./resources/setup_oneroot/Image3"}},
+		Threads:   1,
+		KeepGoing: true,
+	}
+
+	summary, err := BuildImages(config)
+	require.Error(t, err)
+	require.Len(t, summary.Built, 0)
+	require.Len(t, summary.Failed, 1)
+	require.Len(t, summary.Skipped, 2)
+}
+
+func Test_dockerArgs(t *testing.T) {
+	image := Image{
+		ContainerName: "org/api:1.0",
+		Dockerpath:    "/context",
+		ForbidCache:   true,
+		Pull:          true,
+		BuildArgs:     map[string]string{"VERSION": "1.0", "BASE": "org/base"},
+		Labels:        map[string]string{"team": "core"},
+		Target:        "runtime",
+		Platform:      "linux/arm64",
+		Network:       "host",
+		Secrets:       []string{"id=npmrc,src=.npmrc"},
+		SSH:           []string{"default"},
+		ExtraArgs:     []string{"--progress=plain"},
+	}
+
+	require.Equal(t, []string{"build", "--no-cache", "--pull", "--build-arg", "BASE=org/base", "--build-arg", "VERSION=1.0", "--label", "team=core",
+		"--target", "runtime", "--platform", "linux/arm64", "--network", "host", "--secret", "id=npmrc,src=.npmrc", "--ssh", "default",
+		"--progress=plain", "-t", "org/api:1.0", "/context"}, dockerArgs(image, "/context"))
+}
+
+func Test_dockerArgs_Dockerfile(t *testing.T) {
+	tests := []struct {
+		name      string
+		image     Image
+		buildPath string
+		want      []string
+	}{
+		{"test_0", Image{ContainerName: "a", Dockerpath: "/src/a/"}, "/src/a", []string{"build", "-t", "a", "/src/a"}},
+		{"test_1", Image{ContainerName: "a", Dockerpath: "/src/a", DockerfilePath: "Dockerfile.prod"}, "/src/a", []string{"build", "-f", "/src/a/Dockerfile.prod", "-t", "a", "/src/a"}},
+		{"test_2", Image{ContainerName: "a", Dockerpath: "/src/a", Context: "/src"}, "/src", []string{"build", "-f", "/src/a/Dockerfile", "-t", "a", "/src"}},
+		{"test_3", Image{ContainerName: "a", Dockerpath: "/src/a", DockerfilePath: "/docker/Dockerfile.debug"}, "/tmp/1-build", []string{"build", "-f", "/docker/Dockerfile.debug", "-t", "a", "/tmp/1-build"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, dockerArgs(tt.image, tt.buildPath))
+		})
+	}
+}
+
+func Test_builder_Folders(t *testing.T) {
+	// fake docker binary, that lists build context
+	defer fakeDocker(t, "for arg; do context=$arg; done\nls $context\n")()
+
+	folder := t.TempDir()
+	reports := make(chan Report, 1)
+	builder(Image{ContainerName: "org/api", Dockerpath: "./resources/setup_nodeps/Image1", Folders: []string{folder + ":extra"}}, cliBuilder{binary: "docker", args: dockerArgs}, "", reports)
+
+	report := <-reports
+	require.True(t, report.Success)
+	require.Regexp(t, "^Command: docker build .* /.*-build\nDockerfile\nextra\n$", report.Log)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// label that stores fingerprint of the image
+const fingerprintLabel = "krane.fingerprint"
+
+// default location of the incremental build state
+const DefaultStateFile = ".krane.state"
+
+// buildState is stored between runs, so we know fingerprints of the images that were built
+type buildState struct {
+	Images map[string]string `json:"images"`
+}
+
+/*
+	This function loads build state from a given file. Missing file means nothing was built yet
+*/
+func loadState(fileName string) (state buildState, err error) {
+	state.Images = make(map[string]string)
+
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(content, &state)
+	if err == nil && state.Images == nil {
+		state.Images = make(map[string]string)
+	}
+
+	return
+}
+
+/*
+	This function stores build state into a given file
+*/
+func saveState(fileName string, state buildState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(fileName, content, 0644)
+}
+
+/*
+	This function computes fingerprint of the image. It covers Dockerfile, build context, mapped folders,
+	build settings and fingerprints of internal parents, so any change of these results in a new fingerprint.
+	Excluded paths are files krane writes itself, i.e. build state and logs, they are skipped even if they are within build context
+*/
+func fingerprintImage(image Image, parents []string, excluded []string) (fingerprint string, err error) {
+	h := sha256.New()
+
+	// pushing doesn't change the image, and neither do tags: unchanged images are tagged instead of being rebuilt
+	image.Push = nil
+	image.Tags = nil
+	settings, err := json.Marshal(image)
+	if err != nil {
+		return
+	}
+
+	_, _ = fmt.Fprintf(h, "settings %s\n", settings)
+
+	err = hashFile(h, "dockerfile", image.ResolvedDockerfile())
+	if err != nil {
+		return
+	}
+
+	filter, err := readDockerignore(image.BuildContext())
+	if err != nil {
+		return
+	}
+
+	isExcluded, err := excludedPaths(excluded)
+	if err != nil {
+		return
+	}
+
+	err = hashTree(h, image.BuildContext(), "context", func(relative string) (bool, bool) {
+		if isExcluded(filepath.Join(image.BuildContext(), relative)) {
+			return true, true
+		}
+
+		return filter(relative)
+	})
+	if err != nil {
+		return
+	}
+
+	for _, v := range image.Folders {
+		var folder Folder
+		folder, err = NewFolder(v)
+		if err != nil {
+			return
+		}
+
+		source := folder.Source
+		err = hashTree(h, source, "folders/"+folder.Target, func(relative string) (bool, bool) {
+			excluded := isExcluded(filepath.Join(source, relative))
+			return excluded, excluded
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	parents = append([]string{}, parents...)
+	sort.Strings(parents)
+	for _, v := range parents {
+		_, _ = fmt.Fprintf(h, "parent %v\n", v)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// excludedPaths returns function that tells if path is one of given paths, or is within one of them
+func excludedPaths(paths []string) (func(string) bool, error) {
+	var absolute []string
+	for _, v := range paths {
+		if len(v) == 0 {
+			continue
+		}
+
+		path, err := filepath.Abs(v)
+		if err != nil {
+			return nil, err
+		}
+
+		absolute = append(absolute, path)
+	}
+
+	return func(current string) bool {
+		current, err := filepath.Abs(current)
+		if err != nil {
+			return false
+		}
+
+		for _, v := range absolute {
+			if current == v || strings.HasPrefix(current, v+string(filepath.Separator)) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// hashTree adds names, modes and contents of all files within root to the hash, except for the ones filter excludes
+func hashTree(h hash.Hash, root string, prefix string, filter contextFilter) error {
+	return filepath.Walk(root, func(current string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+
+		relative = filepath.ToSlash(relative)
+		if excluded, skip := filter(relative); relative != "." && excluded {
+			if info.IsDir() && skip {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		name := prefix + "/" + relative
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(current)
+			if err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintf(h, "link %v %v\n", name, link)
+		case info.IsDir():
+			_, _ = fmt.Fprintf(h, "dir %v %v\n", name, info.Mode())
+		default:
+			_, _ = fmt.Fprintf(h, "file %v %v\n", name, info.Mode())
+			return hashFile(h, name, current)
+		}
+
+		return nil
+	})
+}
+
+// hashFile adds name and content of a single file to the hash
+func hashFile(h hash.Hash, name string, fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	content := sha256.New()
+	_, err = io.Copy(content, f)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(h, "content %v %x\n", name, content.Sum(nil))
+	return nil
+}
+
+/*
+	This function computes fingerprints of all images, in topological order, and finds images that don't need to be rebuilt.
+	Image is unchanged if backend reports the same fingerprint label for it or, if backend can't tell, if build state has it.
+	Descendants of every image that has to be rebuilt are rebuilt as well
+*/
+func findUnchanged(config BuildConfiguration, namesMap NamesMap, order []string, inDeps, bwdDeps Dependencies, backends map[string]Builder, state buildState) (fingerprints map[string]string, unchanged map[string]bool, err error) {
+	fingerprints = make(map[string]string)
+	unchanged = make(map[string]bool)
+
+	for _, name := range order {
+		var parents []string
+		for _, v := range inDeps[name] {
+			parents = append(parents, v+"="+fingerprints[v])
+		}
+
+		image := config.resolve(namesMap[name])
+		fingerprints[name], err = fingerprintImage(image, parents, []string{config.StatePath(), config.LogDir})
+		if err != nil {
+			return
+		}
+
+		unchanged[name] = isUnchanged(image, fingerprints[name], backends[image.Builder], state.Images[name])
+	}
+
+	// everything downstream of the image that is going to be rebuilt is rebuilt too
+	for _, name := range order {
+		if unchanged[name] {
+			continue
+		}
+
+		queue := append([]string{}, bwdDeps[name]...)
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			if unchanged[current] {
+				unchanged[current] = false
+				queue = append(queue, bwdDeps[current]...)
+			}
+		}
+	}
+
+	return
+}
+
+/*
+	This function tells if image with a given fingerprint was built already. Fingerprint label of the image is the most accurate source,
+	so image that is missing, has no label, or can't be inspected is considered changed. Build state is used only if backend can't read labels
+*/
+func isUnchanged(image Image, fingerprint string, backend Builder, stored string) bool {
+	if inspect, ok := backend.(inspector); ok {
+		label, err := inspect.Label(image.ContainerName, fingerprintLabel)
+		if err != nil {
+			_ = stdout.PrintlnFrom(image.ContainerName, fmt.Sprintf("can't read fingerprint of the image, it's built again: %v", err))
+			return false
+		}
+
+		return len(label) > 0 && label == fingerprint
+	}
+
+	return stored == fingerprint
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeImage creates folder with a Dockerfile and some file within given root, and returns path to it
+func writeImage(t *testing.T, root string, name string, dockerfile string) string {
+	folder := path.Join(root, name)
+	require.NoError(t, os.MkdirAll(folder, 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(folder, "Dockerfile"), []byte(dockerfile), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(folder, "app.txt"), []byte("version 1"), 0644))
+	return folder
+}
+
+func TestFingerprintImage(t *testing.T) {
+	folder := writeImage(t, t.TempDir(), "a", "FROM alpine\nCOPY app.txt /")
+	image := Image{ContainerName: "a", Dockerpath: folder}
+
+	original, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+
+	again, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, original, again)
+
+	// parents are part of the fingerprint
+	withParent, err := fingerprintImage(image, []string{"base:latest=123"}, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, original, withParent)
+
+	// and so are settings
+	image.BuildArgs = map[string]string{"A": "1"}
+	withArgs, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, original, withArgs)
+	image.BuildArgs = nil
+
+	// but not tags, images are tagged instead of being rebuilt
+	image.Tags = []string{"a:1.0"}
+	withTags, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, original, withTags)
+	image.Tags = nil
+
+	// ignored files don't matter
+	require.NoError(t, ioutil.WriteFile(path.Join(folder, ".dockerignore"), []byte("*.log"), 0644))
+	ignored, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path.Join(folder, "build.log"), []byte("log"), 0644))
+	withLog, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, ignored, withLog)
+
+	// but build context does
+	require.NoError(t, ioutil.WriteFile(path.Join(folder, "app.txt"), []byte("version 2"), 0644))
+	changed, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, withLog, changed)
+}
+
+func TestFingerprintImage_Folders(t *testing.T) {
+	root := t.TempDir()
+	folder := writeImage(t, root, "a", "FROM alpine")
+	shared := writeImage(t, root, "shared", "")
+	image := Image{ContainerName: "a", Dockerpath: folder, Folders: []string{shared + ":shared"}}
+
+	original, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(path.Join(shared, "app.txt"), []byte("version 2"), 0644))
+	changed, err := fingerprintImage(image, nil, nil)
+	require.NoError(t, err)
+	require.NotEqual(t, original, changed)
+}
+
+func TestBuildImages_Incremental(t *testing.T) {
+	backend := &fakeBuilder{}
+	defer useFakeBuilder(backend)()
+
+	root := t.TempDir()
+	base := writeImage(t, root, "base", "FROM alpine")
+	app := writeImage(t, root, "app", "FROM base")
+	tool := writeImage(t, root, "tool", "FROM alpine")
+
+	config := BuildConfiguration{
+		Images:      []Image{{ContainerName: "app", Dockerpath: app}, {ContainerName: "base", Dockerpath: base}, {ContainerName: "tool", Dockerpath: tool}},
+		Builder:     "fake",
+		Incremental: true,
+		StateFile:   path.Join(root, "state.json"),
+	}
+
+	summary, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, summary.Built, 3)
+
+	// nothing changed, nothing to build
+	summary, err = BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, summary.Built, 0)
+	require.Len(t, summary.Unchanged, 3)
+
+	// changed parent forces its descendants to be rebuilt
+	backend.built = nil
+	require.NoError(t, ioutil.WriteFile(path.Join(base, "app.txt"), []byte("version 2"), 0644))
+	summary, err = BuildImages(config)
+	require.NoError(t, err)
+	require.Equal(t, []string{"base", "app"}, backend.built)
+	require.Len(t, summary.Unchanged, 1)
+
+	// failed images are rebuilt next time
+	backend.built = nil
+	backend.failing = map[string]bool{"tool": true}
+	require.NoError(t, ioutil.WriteFile(path.Join(tool, "app.txt"), []byte("version 2"), 0644))
+	_, err = BuildImages(config)
+	require.Error(t, err)
+
+	backend.failing = nil
+	summary, err = BuildImages(config)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tool"}, backend.built)
+
+	// new tags are added to the existing image
+	backend.built = nil
+	config.Images[2].Tags = []string{"tool:1.0"}
+	summary, err = BuildImages(config)
+	require.NoError(t, err)
+	require.Empty(t, backend.built)
+	require.Equal(t, []string{"tool:1.0"}, backend.tagged)
+	require.Len(t, summary.Unchanged, 3)
+}
+
+func TestBuildImages_IncrementalStateInContext(t *testing.T) {
+	backend := &fakeBuilder{}
+	defer useFakeBuilder(backend)()
+
+	// state and logs are written into the build context of the image, i.e. dockerpath: .
+	folder := writeImage(t, t.TempDir(), "app", "FROM alpine")
+	config := BuildConfiguration{
+		Images:      []Image{{ContainerName: "app", Dockerpath: folder}},
+		Builder:     "fake",
+		Incremental: true,
+		StateFile:   path.Join(folder, DefaultStateFile),
+		LogDir:      path.Join(folder, "logs"),
+	}
+
+	summary, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, summary.Built, 1)
+	require.FileExists(t, config.StatePath())
+
+	require.NoError(t, os.MkdirAll(config.LogDir, 0755))
+	require.NoError(t, ioutil.WriteFile(logFileName(config.LogDir, "app"), []byte("building app"), 0644))
+	summary, err = BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, summary.Built, 0)
+	require.Len(t, summary.Unchanged, 1)
+}
+
+func TestLoadState_Missing(t *testing.T) {
+	state, err := loadState(path.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	require.Empty(t, state.Images)
+}
+
+// labelBuilder is a backend that reports fingerprint labels
+type labelBuilder struct {
+	fakeBuilder
+	labels map[string]string
+	err    error
+}
+
+func (b *labelBuilder) Label(name string, label string) (string, error) {
+	return b.labels[name], b.err
+}
+
+func Test_isUnchanged(t *testing.T) {
+	image := Image{ContainerName: "org/api"}
+	tests := []struct {
+		name    string
+		backend Builder
+		stored  string
+		want    bool
+	}{
+		{"test_0", &fakeBuilder{}, "abc", true},
+		{"test_1", &fakeBuilder{}, "old", false},
+		{"test_2", &labelBuilder{labels: map[string]string{"org/api": "abc"}}, "", true},
+		// label is more accurate than the state
+		{"test_3", &labelBuilder{labels: map[string]string{"org/api": "old"}}, "abc", false},
+		// no label, or no image at all, means the image is changed, whatever the state says
+		{"test_4", &labelBuilder{}, "abc", false},
+		{"test_5", &labelBuilder{}, "old", false},
+		{"test_6", &labelBuilder{err: fmt.Errorf("No such image: org/api")}, "abc", false},
+		{"test_7", &labelBuilder{err: fmt.Errorf("daemon is not running")}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isUnchanged(image, "abc", tt.backend, tt.stored))
+		})
+	}
+}
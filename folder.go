@@ -7,8 +7,8 @@ import (
 )
 
 type Folder struct {
-	Source string
-	Target string
+	Source string `json:"source"`
+	Target string `json:"target"`
 }
 
 func NewFolder(str string) (f Folder, err error) {
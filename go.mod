@@ -1,9 +1,15 @@
 module github.com/raver119/krane
 
-go 1.16
+go 1.19
 
 require (
+	github.com/moby/patternmatcher v0.6.1
 	github.com/otiai10/copy v1.6.0
 	github.com/stretchr/testify v1.7.0
-	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 )
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Graph is a dependency graph of images, suitable for rendering
+type Graph struct {
+	// Layers hold names of images that can be built in parallel, in build order
+	Layers [][]string `json:"layers"`
+
+	// Nodes are images of the build configuration, in the same order as in Layers
+	Nodes []GraphNode `json:"nodes"`
+
+	// External are base images that aren't built by us, only filled if requested
+	External []string `json:"external,omitempty"`
+
+	// Edges go from parent image to the image that depends on it
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a single image of the build configuration, with settings worth showing on the graph
+type GraphNode struct {
+	Name     string `json:"name"`
+	Layer    int    `json:"layer"`
+	Builder  string `json:"builder"`
+	NoCache  bool   `json:"noCache,omitempty"`
+	Pull     bool   `json:"pull,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Platform string `json:"platform,omitempty"`
+}
+
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	External bool   `json:"external,omitempty"`
+}
+
+// supported formats of the graph
+var graphFormats = map[string]func(Graph) (string, error){
+	"dot":     Graph.DOT,
+	"mermaid": Graph.Mermaid,
+	"json":    Graph.JSON,
+}
+
+/*
+	This function builds dependency graph of the configuration. Images are grouped into layers as in buildExecutableMap,
+	base images that aren't built by us are only added if external is set
+*/
+func buildGraph(config BuildConfiguration, external bool) (graph Graph, err error) {
+	executable, err := buildExecutableMap(config)
+	if err != nil {
+		return
+	}
+
+	extDeps, inDeps, _, err := scanDependencies(config)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < len(executable); i++ {
+		layer := []string{}
+		for _, image := range executable[i] {
+			name := normalizeName(image.ContainerName)
+			layer = append(layer, name)
+			graph.Nodes = append(graph.Nodes, GraphNode{
+				Name:     name,
+				Layer:    i,
+				Builder:  config.BackendOf(image),
+				NoCache:  image.ForbidCache,
+				Pull:     image.Pull,
+				Target:   image.Target,
+				Platform: image.Platform,
+			})
+		}
+
+		graph.Layers = append(graph.Layers, layer)
+	}
+
+	graph.Edges = []GraphEdge{}
+	seen := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		parents := append([]string{}, inDeps[node.Name]...)
+		sort.Strings(parents)
+		for _, v := range parents {
+			graph.Edges = append(graph.Edges, GraphEdge{From: v, To: node.Name})
+		}
+
+		if !external {
+			continue
+		}
+
+		parents = append([]string{}, extDeps[node.Name]...)
+		sort.Strings(parents)
+		for _, v := range parents {
+			graph.Edges = append(graph.Edges, GraphEdge{From: v, To: node.Name, External: true})
+			if !seen[v] {
+				seen[v] = true
+				graph.External = append(graph.External, v)
+			}
+		}
+	}
+
+	sort.Strings(graph.External)
+	return
+}
+
+// attributes returns short description of node settings, worth showing next to its name
+func (n GraphNode) attributes() (attributes []string) {
+	if n.NoCache {
+		attributes = append(attributes, "noCache")
+	}
+
+	if n.Pull {
+		attributes = append(attributes, "pull")
+	}
+
+	if len(n.Target) > 0 {
+		attributes = append(attributes, "target: "+n.Target)
+	}
+
+	if len(n.Platform) > 0 {
+		attributes = append(attributes, "platform: "+n.Platform)
+	}
+
+	if n.Builder != DefaultBuilder {
+		attributes = append(attributes, "builder: "+n.Builder)
+	}
+
+	return
+}
+
+/*
+	This method renders graph in Graphviz DOT language. Every layer is a cluster with the same rank,
+	external images are dashed
+*/
+func (g Graph) DOT() (string, error) {
+	var b strings.Builder
+	b.WriteString("digraph krane {\n")
+	b.WriteString("  node [shape=box];\n")
+
+	for i, layer := range g.Layers {
+		_, _ = fmt.Fprintf(&b, "\n  subgraph cluster_layer_%v {\n", i)
+		_, _ = fmt.Fprintf(&b, "    label=%v;\n", strconv.Quote(fmt.Sprintf("layer %v", i)))
+		b.WriteString("    rank=same;\n")
+		for _, name := range layer {
+			node := g.node(name)
+			label := strings.Join(append([]string{name}, node.attributes()...), "\n")
+			style := ""
+			if node.NoCache {
+				style = ", style=bold"
+			}
+
+			_, _ = fmt.Fprintf(&b, "    %v [label=%v%v];\n", strconv.Quote(name), strconv.Quote(label), style)
+		}
+
+		b.WriteString("  }\n")
+	}
+
+	if len(g.External) > 0 {
+		b.WriteString("\n")
+	}
+
+	for _, name := range g.External {
+		_, _ = fmt.Fprintf(&b, "  %v [style=dashed];\n", strconv.Quote(name))
+	}
+
+	if len(g.Edges) > 0 {
+		b.WriteString("\n")
+	}
+
+	for _, edge := range g.Edges {
+		style := ""
+		if edge.External {
+			style = " [style=dashed]"
+		}
+
+		_, _ = fmt.Fprintf(&b, "  %v -> %v%v;\n", strconv.Quote(edge.From), strconv.Quote(edge.To), style)
+	}
+
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+/*
+	This method renders graph as Mermaid flowchart. Every layer is a subgraph, external images are drawn
+	as stadiums with dotted edges
+*/
+func (g Graph) Mermaid() (string, error) {
+	// mermaid identifiers can't contain most of characters allowed in image names, so we use indices instead
+	ids := make(map[string]string)
+	for i, node := range g.Nodes {
+		ids[node.Name] = fmt.Sprintf("n%v", i)
+	}
+
+	for i, name := range g.External {
+		ids[name] = fmt.Sprintf("x%v", i)
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+
+	for i, layer := range g.Layers {
+		_, _ = fmt.Fprintf(&b, "  subgraph layer_%v [\"layer %v\"]\n", i, i)
+		for _, name := range layer {
+			label := strings.Join(append([]string{name}, g.node(name).attributes()...), "<br/>")
+			_, _ = fmt.Fprintf(&b, "    %v[\"%v\"]\n", ids[name], mermaidEscape(label))
+		}
+
+		b.WriteString("  end\n")
+	}
+
+	for _, name := range g.External {
+		_, _ = fmt.Fprintf(&b, "  %v([\"%v\"])\n", ids[name], mermaidEscape(name))
+	}
+
+	for _, edge := range g.Edges {
+		arrow := "-->"
+		if edge.External {
+			arrow = "-.->"
+		}
+
+		_, _ = fmt.Fprintf(&b, "  %v %v %v\n", ids[edge.From], arrow, ids[edge.To])
+	}
+
+	return b.String(), nil
+}
+
+// JSON renders graph as indented JSON document
+func (g Graph) JSON() (string, error) {
+	content, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(content) + "\n", nil
+}
+
+// node returns node with a given name
+func (g Graph) node(name string) GraphNode {
+	for _, v := range g.Nodes {
+		if v.Name == name {
+			return v
+		}
+	}
+
+	return GraphNode{Name: name}
+}
+
+// mermaidEscape replaces quotes, that would terminate mermaid label, with entity code
+func mermaidEscape(label string) string {
+	return strings.ReplaceAll(label, "\"", "#quot;")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func graphConfig() BuildConfiguration {
+	return BuildConfiguration{
+		Images: []Image{
+			{ContainerName: "image1", Dockerpath: "./resources/setup_oneroot/Image1", ForbidCache: true},
+			{ContainerName: "image2", Dockerpath: "./resources/setup_oneroot/Image2"},
+			{ContainerName: "image3", Dockerpath: "./resources/setup_oneroot/Image3", Target: "prod", Builder: "podman"},
+		},
+	}
+}
+
+func Test_buildGraph(t *testing.T) {
+	graph, err := buildGraph(graphConfig(), false)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"image2:latest"}, {"image1:latest", "image3:latest"}}, graph.Layers)
+	require.Equal(t, []GraphNode{
+		{Name: "image2:latest", Layer: 0, Builder: "docker"},
+		{Name: "image1:latest", Layer: 1, Builder: "docker", NoCache: true},
+		{Name: "image3:latest", Layer: 1, Builder: "podman", Target: "prod"},
+	}, graph.Nodes)
+	require.Empty(t, graph.External)
+	require.Equal(t, []GraphEdge{{From: "image2:latest", To: "image1:latest"}, {From: "image2:latest", To: "image3:latest"}}, graph.Edges)
+
+	graph, err = buildGraph(graphConfig(), true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ubuntu:latest"}, graph.External)
+	require.Contains(t, graph.Edges, GraphEdge{From: "ubuntu:latest", To: "image2:latest", External: true})
+}
+
+func TestGraph_DOT(t *testing.T) {
+	graph, err := buildGraph(graphConfig(), true)
+	require.NoError(t, err)
+
+	dot, err := graph.DOT()
+	require.NoError(t, err)
+	require.Equal(t, `digraph krane {
+  node [shape=box];
+
+  subgraph cluster_layer_0 {
+    label="layer 0";
+    rank=same;
+    "image2:latest" [label="image2:latest"];
+  }
+
+  subgraph cluster_layer_1 {
+    label="layer 1";
+    rank=same;
+    "image1:latest" [label="image1:latest\nnoCache", style=bold];
+    "image3:latest" [label="image3:latest\ntarget: prod\nbuilder: podman"];
+  }
+
+  "ubuntu:latest" [style=dashed];
+
+  "ubuntu:latest" -> "image2:latest" [style=dashed];
+  "image2:latest" -> "image1:latest";
+  "image2:latest" -> "image3:latest";
+}
+`, dot)
+}
+
+func TestGraph_Mermaid(t *testing.T) {
+	graph, err := buildGraph(graphConfig(), true)
+	require.NoError(t, err)
+
+	mermaid, err := graph.Mermaid()
+	require.NoError(t, err)
+	require.Equal(t, `flowchart TD
+  subgraph layer_0 ["layer 0"]
+    n0["image2:latest"]
+  end
+  subgraph layer_1 ["layer 1"]
+    n1["image1:latest<br/>noCache"]
+    n2["image3:latest<br/>target: prod<br/>builder: podman"]
+  end
+  x0(["ubuntu:latest"])
+  x0 -.-> n0
+  n0 --> n1
+  n0 --> n2
+`, mermaid)
+}
+
+func TestGraph_JSON(t *testing.T) {
+	graph, err := buildGraph(graphConfig(), false)
+	require.NoError(t, err)
+
+	content, err := graph.JSON()
+	require.NoError(t, err)
+
+	var decoded Graph
+	require.NoError(t, json.Unmarshal([]byte(content), &decoded))
+	require.Equal(t, graph, decoded)
+	require.Contains(t, content, `"noCache": true`)
+}
@@ -1,8 +1,140 @@
 package main
 
+import "path/filepath"
+
 type Image struct {
-	Folders       []string `yaml:"folders"`
-	ContainerName string   `yaml:"containerName"`
-	Dockerpath    string   `yaml:"dockerpath"`
-	ForbidCache   bool     `yaml:"noCache"`
+	Folders       []string `yaml:"folders" json:"folders,omitempty"`
+	ContainerName string   `yaml:"containerName" json:"containerName,omitempty"`
+	Dockerpath    string   `yaml:"dockerpath" json:"dockerpath,omitempty"`
+	ForbidCache   bool     `yaml:"noCache" json:"noCache,omitempty"`
+
+	// DockerfilePath is a Dockerfile to use instead of Dockerfile in Dockerpath, relative to Dockerpath
+	DockerfilePath string `yaml:"dockerfile,omitempty" json:"dockerfile,omitempty"`
+
+	// Context is a build context, if it's different from Dockerpath
+	Context string `yaml:"context,omitempty" json:"context,omitempty"`
+
+	// BuildArgs are passed to docker as --build-arg, and used to resolve ARGs in FROM
+	BuildArgs map[string]string `yaml:"buildArgs,omitempty" json:"buildArgs,omitempty"`
+
+	// Target is a stage of multi-stage Dockerfile to build
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`
+
+	// Labels are added to the image metadata
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+
+	// Platform to build image for, i.e. linux/arm64
+	Platform string `yaml:"platform,omitempty" json:"platform,omitempty"`
+
+	// Pull makes docker always pull newer versions of base images
+	Pull bool `yaml:"pull,omitempty" json:"pull,omitempty"`
+
+	// Network mode for RUN instructions
+	Network string `yaml:"network,omitempty" json:"network,omitempty"`
+
+	// Secrets exposed to the build, i.e. id=npmrc,src=/home/user/.npmrc
+	Secrets []string `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+
+	// SSH agent sockets or keys exposed to the build, i.e. default
+	SSH []string `yaml:"ssh,omitempty" json:"ssh,omitempty"`
+
+	// ExtraArgs are appended to docker build command as is
+	ExtraArgs []string `yaml:"extraArgs,omitempty" json:"extraArgs,omitempty"`
+
+	// Builder is a backend used for this image, i.e. docker, podman or buildah
+	Builder string `yaml:"builder,omitempty" json:"builder,omitempty"`
+
+	// Tags are additional names of the image, i.e. org/api:{{.ShortSHA}}. See tagVariables for available templates
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+
+	// Push overrides push.enabled of the configuration for this image
+	Push *bool `yaml:"push,omitempty" json:"push,omitempty"`
+}
+
+/*
+This method returns build args of the image, with overrides applied on top of them
+*/
+func (i Image) EffectiveBuildArgs(overrides map[string]string) map[string]string {
+	if len(i.BuildArgs) == 0 && len(overrides) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string)
+	for k, v := range i.BuildArgs {
+		result[k] = v
+	}
+
+	for k, v := range overrides {
+		result[k] = v
+	}
+
+	return result
+}
+
+/*
+This method returns path to the Dockerfile of the image
+*/
+func (i Image) ResolvedDockerfile() string {
+	if len(i.DockerfilePath) == 0 {
+		return filepath.Join(i.Dockerpath, "Dockerfile")
+	}
+
+	if filepath.IsAbs(i.DockerfilePath) {
+		return filepath.Clean(i.DockerfilePath)
+	}
+
+	return filepath.Join(i.Dockerpath, i.DockerfilePath)
+}
+
+/*
+This method returns build context of the image
+*/
+func (i Image) BuildContext() string {
+	if len(i.Context) > 0 {
+		return i.Context
+	}
+
+	return i.Dockerpath
+}
+
+// withLabel returns copy of labels with one more label added
+func withLabel(labels map[string]string, name string, value string) map[string]string {
+	result := map[string]string{name: value}
+	for k, v := range labels {
+		if k != name {
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+/*
+	This method checks if image has to be pushed once it's built
+*/
+func (i Image) Pushed() bool {
+	return i.Push != nil && *i.Push
+}
+
+/*
+	This method returns references the image is tagged with, as they're given: container name goes first, followed by tags
+*/
+func (i Image) References() []string {
+	return append([]string{i.ContainerName}, i.Tags...)
+}
+
+/*
+	This method returns all names of the image, normalized: container name goes first, followed by tags
+*/
+func (i Image) Names() (names []string) {
+	seen := make(map[string]bool)
+	for _, v := range i.References() {
+		name := normalizeName(v)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return
 }
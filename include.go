@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// configLoader loads configuration files along with everything they include, and merges them into one configuration
+type configLoader struct {
+	vars map[string]string
+
+	// files that were loaded already, by absolute path
+	loaded map[string]bool
+
+	// file every image name, tags included, was declared in
+	sources map[string]string
+
+	result BuildConfiguration
+}
+
+/*
+	This function loads given configuration files, with all of their includes, and merges them into one configuration.
+	Settings of earlier files take precedence, images of all files are built as one graph.
+	Relative paths within every file, included or given, are relative to that file
+*/
+func LoadFiles(fileNames []string, vars map[string]string) (BuildConfiguration, error) {
+	loader := configLoader{vars: vars, loaded: make(map[string]bool), sources: make(map[string]string)}
+	for _, v := range fileNames {
+		err := loader.load(v)
+		if err != nil {
+			return loader.result, err
+		}
+	}
+
+	SortImages(&loader.result)
+	return loader.result, nil
+}
+
+// load reads single file, merges it into the result, and loads files it includes
+func (l *configLoader) load(fileName string) (err error) {
+	absolute, err := filepath.Abs(fileName)
+	if err != nil {
+		return
+	}
+
+	// the same file can be included by several others, its images are added only once
+	if l.loaded[absolute] {
+		return
+	}
+
+	l.loaded[absolute] = true
+	config, err := ParseFileWithVars(fileName, l.vars)
+	if err != nil {
+		return
+	}
+
+	dir := filepath.Dir(fileName)
+	for i := range config.Images {
+		config.Images[i] = relativeTo(dir, config.Images[i])
+	}
+
+	if len(config.LogDir) > 0 {
+		config.LogDir = joinPath(dir, config.LogDir)
+	}
+
+	if len(config.StateFile) > 0 {
+		config.StateFile = joinPath(dir, config.StateFile)
+	}
+
+	config.Dir = dir
+
+	err = l.merge(fileName, config)
+	if err != nil {
+		return
+	}
+
+	for _, v := range config.Include {
+		err = l.load(joinPath(dir, v))
+		if err != nil {
+			return fmt.Errorf("%v, included from %v", err, fileName)
+		}
+	}
+
+	return
+}
+
+// merge adds images of the configuration to the result. Settings are taken only if they aren't set yet
+func (l *configLoader) merge(fileName string, config BuildConfiguration) error {
+	for _, image := range config.Images {
+		for _, name := range image.Names() {
+			if source, has := l.sources[name]; has && source == fileName {
+				return fmt.Errorf("image [%v] is declared more than once in %v", name, fileName)
+			} else if has {
+				return fmt.Errorf("image [%v] is declared in both %v and %v", name, source, fileName)
+			}
+
+			l.sources[name] = fileName
+		}
+
+		l.result.Images = append(l.result.Images, image)
+	}
+
+	r := &l.result
+	if r.Threads == 0 {
+		r.Threads = config.Threads
+	}
+
+	if len(r.LogDir) == 0 {
+		r.LogDir = config.LogDir
+	}
+
+	if len(r.StateFile) == 0 {
+		r.StateFile = config.StateFile
+	}
+
+	if len(r.Dir) == 0 {
+		r.Dir = config.Dir
+	}
+
+	if len(r.Builder) == 0 {
+		r.Builder = config.Builder
+	}
+
+	if len(r.Output.Color) == 0 {
+		r.Output.Color = config.Output.Color
+	}
+
+	if r.Push == (PushConfiguration{}) {
+		r.Push = config.Push
+	}
+
+	r.KeepGoing = r.KeepGoing || config.KeepGoing
+	r.Incremental = r.Incremental || config.Incremental
+	r.Output.NoPrefix = r.Output.NoPrefix || config.Output.NoPrefix
+	r.Output.Quiet = r.Output.Quiet || config.Output.Quiet
+	return nil
+}
+
+// relativeTo resolves relative paths of the image, i.e. dockerpath, context, folders and sources of secrets, against a given folder
+func relativeTo(dir string, image Image) Image {
+	if len(image.Dockerpath) > 0 {
+		image.Dockerpath = joinPath(dir, image.Dockerpath)
+	}
+
+	if len(image.Context) > 0 {
+		image.Context = joinPath(dir, image.Context)
+	}
+
+	folders := make([]string, len(image.Folders))
+	for i, v := range image.Folders {
+		// folder is either source or source:target
+		split := strings.SplitN(v, ":", 2)
+		split[0] = joinPath(dir, split[0])
+		folders[i] = strings.Join(split, ":")
+	}
+
+	image.Folders = folders
+
+	// secret is a list of options, i.e. id=npmrc,src=.npmrc, only its source is a path
+	secrets := make([]string, len(image.Secrets))
+	for i, v := range image.Secrets {
+		options := strings.Split(v, ",")
+		for j, option := range options {
+			if key := strings.SplitN(option, "=", 2); len(key) == 2 && (key[0] == "src" || key[0] == "source") {
+				options[j] = key[0] + "=" + joinPath(dir, key[1])
+			}
+		}
+
+		secrets[i] = strings.Join(options, ",")
+	}
+
+	if len(image.Secrets) > 0 {
+		image.Secrets = secrets
+	}
+
+	return image
+}
+
+// joinPath resolves relative path against a given folder, absolute paths are left as is
+func joinPath(dir string, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+
+	return filepath.Join(dir, name)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfigs creates configuration files within a temporary folder, and returns that folder
+func writeConfigs(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for k, v := range files {
+		name := filepath.Join(root, k)
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, os.WriteFile(name, []byte(v), 0644))
+	}
+
+	return root
+}
+
+func TestLoadFiles(t *testing.T) {
+	root := writeConfigs(t, map[string]string{
+		"krane.yaml": `
+threads: 4
+include:
+  - services/krane.yaml
+build:
+  - containerName: org/base
+    dockerpath: ./base
+`,
+		"services/krane.yaml": `
+threads: 8
+keepGoing: true
+include:
+  - ../shared.yaml
+build:
+  - containerName: org/api
+    dockerpath: ./api
+    folders:
+      - ../assets:/assets
+`,
+		"shared.yaml": `
+build:
+  - containerName: org/worker
+    dockerpath: worker
+    context: .
+`,
+		"other.yaml": `
+include:
+  - shared.yaml
+build:
+  - containerName: org/web
+    dockerpath: ./web
+`,
+		"duplicate.yaml": `
+build:
+  - containerName: org/api:latest
+    dockerpath: ./api
+`,
+		"twice.yaml": `
+build:
+  - containerName: org/api
+    dockerpath: ./api
+  - containerName: org/api:latest
+    dockerpath: ./api
+`,
+		"broken.yaml": `
+include:
+  - missing.yaml
+`,
+	})
+
+	tests := []struct {
+		name    string
+		files   []string
+		want    map[string]Image
+		wantErr string
+	}{
+		{"test_0", []string{"krane.yaml"}, map[string]Image{
+			"org/base":   {ContainerName: "org/base", Dockerpath: "base", Folders: []string{}},
+			"org/api":    {ContainerName: "org/api", Dockerpath: "services/api", Folders: []string{"assets:/assets"}},
+			"org/worker": {ContainerName: "org/worker", Dockerpath: "worker", Context: ".", Folders: []string{}},
+		}, ""},
+		// shared.yaml is included by both files, but is loaded once
+		{"test_1", []string{"krane.yaml", "other.yaml"}, map[string]Image{
+			"org/base":   {ContainerName: "org/base", Dockerpath: "base", Folders: []string{}},
+			"org/api":    {ContainerName: "org/api", Dockerpath: "services/api", Folders: []string{"assets:/assets"}},
+			"org/worker": {ContainerName: "org/worker", Dockerpath: "worker", Context: ".", Folders: []string{}},
+			"org/web":    {ContainerName: "org/web", Dockerpath: "web", Folders: []string{}},
+		}, ""},
+		{"test_2", []string{"krane.yaml", "duplicate.yaml"}, nil, "image [org/api:latest] is declared in both services/krane.yaml and duplicate.yaml"},
+		{"test_3", []string{"twice.yaml"}, nil, "image [org/api:latest] is declared more than once in twice.yaml"},
+		{"test_4", []string{"broken.yaml"}, nil, "included from broken.yaml"},
+	}
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(root))
+	defer os.Chdir(wd)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadFiles(tt.files, nil)
+			if len(tt.wantErr) > 0 {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, 4, config.Threads)
+			require.True(t, config.KeepGoing)
+
+			got := make(map[string]Image)
+			for _, v := range config.Images {
+				got[v.ContainerName] = v
+			}
+
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLoadFiles_Dependencies(t *testing.T) {
+	resources, err := filepath.Abs("./resources/setup_oneroot")
+	require.NoError(t, err)
+
+	// image1 and image3 are built FROM image2, which is declared in another file
+	root := writeConfigs(t, map[string]string{
+		"base.yaml": `
+build:
+  - containerName: image2
+    dockerpath: ` + filepath.Join(resources, "Image2") + `
+`,
+		"krane.yaml": `
+include:
+  - base.yaml
+build:
+  - containerName: image1
+    dockerpath: ` + filepath.Join(resources, "Image1") + `
+  - containerName: image3
+    dockerpath: ` + filepath.Join(resources, "Image3") + `
+`,
+	})
+
+	config, err := LoadFiles([]string{filepath.Join(root, "krane.yaml")}, nil)
+	require.NoError(t, err)
+
+	layers, err := buildExecutableMap(config)
+	require.NoError(t, err)
+	require.Len(t, layers, 2)
+	require.Len(t, layers[0], 1)
+	require.Equal(t, "image2", layers[0][0].ContainerName)
+	require.Len(t, layers[1], 2)
+}
+
+func TestLoadFiles_IncludedPaths(t *testing.T) {
+	root := writeConfigs(t, map[string]string{
+		"krane.yaml": `
+include:
+  - services/krane.yaml
+build:
+  - containerName: org/base
+    dockerpath: ./base
+    secrets:
+      - id=npmrc,src=./npmrc
+`,
+		"services/krane.yaml": `
+logDir: ./logs
+stateFile: .krane.state
+build:
+  - containerName: org/api
+    dockerpath: ./api
+    secrets:
+      - id=npmrc,src=./npmrc
+      - id=token,env=TOKEN
+      - id=key,source=/etc/key
+`,
+	})
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(root))
+	defer os.Chdir(wd)
+
+	// paths of every file are relative to that file, the one given on the command line included
+	config, err := LoadFiles([]string{"krane.yaml"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "services/logs", config.LogDir)
+	require.Equal(t, "services/.krane.state", config.StateFile)
+
+	secrets := make(map[string][]string)
+	for _, v := range config.Images {
+		secrets[v.ContainerName] = v.Secrets
+	}
+
+	require.Equal(t, map[string][]string{
+		"org/base": {"id=npmrc,src=npmrc"},
+		"org/api":  {"id=npmrc,src=services/npmrc", "id=token,env=TOKEN", "id=key,source=/etc/key"},
+	}, secrets)
+}
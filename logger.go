@@ -2,20 +2,64 @@ package main
 
 import (
 	"fmt"
+	"hash/fnv"
 	"os"
 	"sync"
 )
 
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// ANSI colors used for sources, bright ones included so neighbours are easier to tell apart
+var palette = []int{31, 32, 33, 34, 35, 36, 91, 92, 93, 94, 95, 96}
+
 type Logger struct {
 	file  *os.File
 	mutex sync.Mutex
+
+	// prefix lines with their source
+	prefix bool
+
+	// paint prefixes, each source gets its own color
+	color bool
+
+	// suppress lines that came from sources
+	quiet bool
 }
 
 func NewLogger(file *os.File) *Logger {
 	return &Logger{
-		file:  file,
-		mutex: sync.Mutex{},
+		file:   file,
+		mutex:  sync.Mutex{},
+		prefix: true,
+		color:  isTerminal(file),
+	}
+}
+
+/*
+	This method applies output settings to the logger
+*/
+func (l *Logger) Configure(output OutputConfiguration) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	switch output.Color {
+	case "", ColorAuto:
+		l.color = isTerminal(l.file)
+	case ColorAlways:
+		l.color = true
+	case ColorNever:
+		l.color = false
+	default:
+		return fmt.Errorf("unknown color mode [%v], expected one of: %v, %v, %v", output.Color, ColorAuto, ColorAlways, ColorNever)
 	}
+
+	l.prefix = !output.NoPrefix
+	l.quiet = output.Quiet
+	return
 }
 
 func (l *Logger) Print(str string) (err error) {
@@ -33,3 +77,48 @@ func (l *Logger) Println(str string) (err error) {
 	_, err = fmt.Fprintln(l.file, str)
 	return
 }
+
+/*
+	This method prints a line that was produced by the given source, i.e. docker output of some image
+*/
+func (l *Logger) PrintlnFrom(source string, str string) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.quiet {
+		return
+	}
+
+	if l.prefix {
+		str = l.decorate(source) + " " + str
+	}
+
+	_, err = fmt.Fprintln(l.file, str)
+	return
+}
+
+// decorate returns prefix for a given source
+func (l *Logger) decorate(source string) string {
+	if !l.color {
+		return "[" + source + "]"
+	}
+
+	return fmt.Sprintf("\x1b[%vm[%v]\x1b[0m", colorOf(source), source)
+}
+
+// colorOf returns ANSI color for a given source. The same source always gets the same color
+func colorOf(source string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(source))
+	return palette[h.Sum32()%uint32(len(palette))]
+}
+
+// isTerminal checks if given file is attached to a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
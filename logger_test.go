@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func loggedLines(t *testing.T, output OutputConfiguration, source string, line string) string {
+	file, err := ioutil.TempFile(t.TempDir(), "*.log")
+	require.NoError(t, err)
+	defer file.Close()
+
+	l := NewLogger(file)
+	require.NoError(t, l.Configure(output))
+	require.NoError(t, l.PrintlnFrom(source, line))
+
+	content, err := ioutil.ReadFile(file.Name())
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestLogger_PrintlnFrom(t *testing.T) {
+	require.Equal(t, "[org/api:latest] Step 3/9\n", loggedLines(t, OutputConfiguration{}, "org/api:latest", "Step 3/9"))
+	require.Equal(t, "Step 3/9\n", loggedLines(t, OutputConfiguration{NoPrefix: true}, "org/api:latest", "Step 3/9"))
+	require.Equal(t, "", loggedLines(t, OutputConfiguration{Quiet: true}, "org/api:latest", "Step 3/9"))
+
+	colored := fmt.Sprintf("\x1b[%vm[org/api:latest]\x1b[0m Step 3/9\n", colorOf("org/api:latest"))
+	require.Equal(t, colored, loggedLines(t, OutputConfiguration{Color: ColorAlways}, "org/api:latest", "Step 3/9"))
+}
+
+func TestLogger_Configure(t *testing.T) {
+	l := NewLogger(os.Stdout)
+	require.Error(t, l.Configure(OutputConfiguration{Color: "rainbow"}))
+}
+
+func Test_colorOf(t *testing.T) {
+	// color must be stable for the same source
+	require.Equal(t, colorOf("org/api:latest"), colorOf("org/api:latest"))
+	require.Contains(t, palette, colorOf("org/worker:latest"))
+}
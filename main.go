@@ -1,52 +1,286 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// exit codes of krane
+const (
+	// exitSuccess means that everything was done
+	exitSuccess = 0
+
+	// exitFailure means that build failed, and no image was built
+	exitFailure = 1
+
+	// exitConfig means that configuration or command line is wrong, or anything else failed before build started
+	exitConfig = 2
+
+	// exitPartial means that some images were built, but others failed or were skipped
+	exitPartial = 3
+)
+
+// environment variables used when corresponding flags aren't given
+const (
+	envConfig  = "KRANE_CONFIG"
+	envThreads = "KRANE_THREADS"
+)
+
+// exitError makes krane exit with a given code, instead of the default one
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+// command is a subcommand of krane. setup registers flags of the command, and returns function that runs it with positional arguments
+type command struct {
+	usage       string
+	description string
+	setup       func(flags *flag.FlagSet) func(args []string) error
+}
+
+// commands are listed in help in this order
+var commandNames = []string{"build", "plan", "apply", "graph", "validate", "discover", "clean"}
+
+var commands = map[string]command{
+	"build": {
+		usage:       "build [flags] [targets...]",
+		description: "Builds images of the configuration, in parallel and with respect to their dependencies. Targets limit build to images matching them, by name or glob pattern, plus their ancestors.",
+		setup:       buildCommand,
+	},
+	"plan": {
+		usage:       "plan [flags] [targets...]",
+		description: "Writes fully resolved build plan as JSON, without building anything. The plan can be built later with apply.",
+		setup:       planCommand,
+	},
+	"apply": {
+		usage:       "apply [flags] plan.json",
+		description: "Builds images exactly as the plan says, without reading configuration or Dockerfiles again.",
+		setup:       applyCommand,
+	},
+	"graph": {
+		usage:       "graph [flags]",
+		description: "Renders dependency graph of the images as DOT, Mermaid or JSON.",
+		setup:       graphCommand,
+	},
+	"validate": {
+		usage:       "validate [flags]",
+		description: "Strictly validates configuration, and reports every problem found along with its location.",
+		setup:       validateCommand,
+	},
+	"discover": {
+		usage:       "discover [flags] root",
+		description: "Finds Dockerfiles under the root folder, and prints configuration that builds them, or updates existing configuration file.",
+		setup:       discoverCommand,
+	},
+	"clean": {
+		usage:       "clean [flags]",
+		description: "Removes state of incremental builds and build logs.",
+		setup:       cleanCommand,
+	},
+}
+
 func main() {
-	var err error
-	var configFile string
-	var dockerfile string
-	var dryRun bool
-	var name string
-	var folder string
-
-	var buildConfiguration BuildConfiguration
-
-	// parse configuration flags from command line
-	flag.StringVar(&folder, "folders", "", "Folders to include in docker")
-	flag.StringVar(&name, "name", "", "Image name")
-	flag.StringVar(&dockerfile, "dockerfile", "", "Full path to the dockerfile")
-	flag.StringVar(&configFile, "f", "", "Path to build configuration file")
-	flag.BoolVar(&dryRun, "d", false, "Don't run docker, only build and print sorted map")
-	flag.Parse()
-
-	// if configFile is specified - deserialize it
-	if len(configFile) > 0 {
-		// Exit if something is off
-		_ = ValidatePath(configFile, true)
-
-		// get configuration
-		buildConfiguration, err = ParseFile(configFile)
+	os.Exit(run(os.Args[1:]))
+}
+
+/*
+	This function runs command given by arguments, and returns exit code.
+	Flags without command mean build, so krane -f build.yaml works as it always did
+*/
+func run(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitConfig
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		if len(args) > 1 {
+			if c, has := commands[args[1]]; has {
+				flags := newFlagSet(args[1], c)
+				flags.SetOutput(os.Stdout)
+				c.setup(flags)
+				flags.Usage()
+				return exitSuccess
+			}
+		}
+
+		printUsage(os.Stdout)
+		return exitSuccess
+	}
+
+	name := args[0]
+	if strings.HasPrefix(name, "-") {
+		name = "build"
+	} else {
+		args = args[1:]
+	}
+
+	c, has := commands[name]
+	if !has {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command [%v]\n\n", name)
+		printUsage(os.Stderr)
+		return exitConfig
+	}
+
+	// flag package reports its own errors, along with usage
+	flags := newFlagSet(name, c)
+	execute := c.setup(flags)
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitSuccess
+	} else if err != nil {
+		return exitConfig
+	}
+
+	err = execute(flags.Args())
+	if err == nil {
+		return exitSuccess
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "%v\n", err.Error())
+	var e exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+
+	return exitConfig
+}
+
+// newFlagSet creates flags of the command, with help text of that command
+func newFlagSet(name string, c command) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "Usage: krane %v\n\n%v\n\nFlags:\n", c.usage, c.description)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// printUsage prints list of commands, environment variables and exit codes
+func printUsage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Usage: krane <command> [flags]\n\nCommands:\n")
+	for _, name := range commandNames {
+		// first sentence is enough here, the rest is shown by help of the command
+		summary := strings.SplitN(commands[name].description, ". ", 2)[0]
+		_, _ = fmt.Fprintf(w, "  %-10v %v\n", name, strings.TrimSuffix(summary, "."))
+	}
+
+	_, _ = fmt.Fprintf(w, "\nRun krane help <command> to see flags of the command.\n")
+	_, _ = fmt.Fprintf(w, "\nEnvironment:\n")
+	_, _ = fmt.Fprintf(w, "  %-14v configuration files, used if -f isn't given. Several files are separated by %q\n", envConfig, string(os.PathListSeparator))
+	_, _ = fmt.Fprintf(w, "  %-14v number of images built in parallel, used if -j isn't given\n", envThreads)
+	_, _ = fmt.Fprintf(w, "\nExit codes:\n")
+	_, _ = fmt.Fprintf(w, "  %v  success\n", exitSuccess)
+	_, _ = fmt.Fprintf(w, "  %v  build failed, no image was built\n", exitFailure)
+	_, _ = fmt.Fprintf(w, "  %v  configuration or command line is wrong, nothing was built\n", exitConfig)
+	_, _ = fmt.Fprintf(w, "  %v  some images were built, but others failed or were skipped\n", exitPartial)
+}
+
+// fileFlags are command line flags that select configuration files, shared by every command that reads configuration
+type fileFlags struct {
+	configFiles listFlag
+	vars        keyValueFlag
+}
+
+func (f *fileFlags) register(flags *flag.FlagSet) {
+	f.vars = make(keyValueFlag)
+	flags.Var(&f.configFiles, "f", "Path to build configuration file, can be repeated to use images of several files together ($"+envConfig+")")
+	flags.Var(f.vars, "var", "Variable KEY=VALUE used for ${KEY} within configuration, can be repeated")
+}
+
+// names returns configuration files given on the command line, or in the environment if there are none
+func (f *fileFlags) names() []string {
+	if len(f.configFiles) > 0 {
+		return f.configFiles
+	}
+
+	return filepath.SplitList(os.Getenv(envConfig))
+}
+
+/*
+	This method reads and merges configuration files. At least one file must be given
+*/
+func (f *fileFlags) load() (BuildConfiguration, error) {
+	names := f.names()
+	if len(names) == 0 {
+		return BuildConfiguration{}, fmt.Errorf("-f or %v must be specified", envConfig)
+	}
+
+	for _, v := range names {
+		err := ValidatePath(v)
+		if err != nil {
+			return BuildConfiguration{}, err
+		}
+	}
+
+	return LoadFiles(names, f.vars)
+}
+
+// configurationFlags are command line flags that define what is built, and how
+type configurationFlags struct {
+	fileFlags
+	dockerfile  string
+	name        string
+	folder      string
+	backend     string
+	incremental bool
+	stateFile   string
+	since       string
+	only        bool
+	downstream  bool
+	push        bool
+	buildArgs   keyValueFlag
+}
+
+func (c *configurationFlags) register(flags *flag.FlagSet) {
+	c.fileFlags.register(flags)
+	c.buildArgs = make(keyValueFlag)
+	flags.StringVar(&c.dockerfile, "dockerfile", "", "Folder with Dockerfile to build a single image without configuration file, along with -name")
+	flags.StringVar(&c.name, "name", "", "Name of the single image built with -dockerfile")
+	flags.StringVar(&c.folder, "folders", "", "Comma separated folders to copy into build context of the single image built with -dockerfile")
+	flags.Var(c.buildArgs, "build-arg", "Build arg KEY=VALUE passed to every image, can be repeated")
+	flags.StringVar(&c.backend, "builder", "", "Backend to build images with: docker, podman, buildah or engine")
+	flags.BoolVar(&c.incremental, "incremental", false, "Skip images that didn't change since the last build")
+	flags.StringVar(&c.stateFile, "state", "", "File to store fingerprints of built images in, for incremental builds")
+	flags.StringVar(&c.since, "since", "", "Build only images affected by files changed since this git ref, and everything downstream of them")
+	flags.BoolVar(&c.only, "only", false, "Build only target images, without their ancestors")
+	flags.BoolVar(&c.downstream, "downstream", false, "Build everything that depends on target images as well")
+	flags.BoolVar(&c.push, "push", false, "Push every image once it's built")
+}
+
+/*
+	This method reads build configuration, either from configuration files or from a single Dockerfile,
+	and applies command line flags on top of it. targets are positional arguments
+*/
+func (c *configurationFlags) load(targets []string) (buildConfiguration BuildConfiguration, err error) {
+	if len(c.names()) > 0 {
+		buildConfiguration, err = c.fileFlags.load()
 		if err != nil {
-			log.Fatal(err)
+			return
 		}
-	} else if len(dockerfile) > 0 {
-		if len(name) == 0 {
-			log.Fatal("-name must be specified")
+	} else if len(c.dockerfile) > 0 {
+		if len(c.name) == 0 {
+			return buildConfiguration, fmt.Errorf("-name must be specified")
 		}
 
 		var folders []string
-		if len(folder) > 0 {
-			folders = strings.Split(folder, ",")
+		if len(c.folder) > 0 {
+			folders = strings.Split(c.folder, ",")
 			err = checkFoldersExistence(folders...)
 			if err != nil {
-				log.Fatal(err)
+				return
 			}
 		}
 
@@ -54,35 +288,124 @@ func main() {
 		buildConfiguration = BuildConfiguration{
 			Images: []Image{{
 				Folders:       folders,
-				ContainerName: name,
-				Dockerpath:    dockerfile,
+				ContainerName: c.name,
+				Dockerpath:    c.dockerfile,
 				ForbidCache:   false,
 			}},
 		}
 	} else {
-		// show error & exit
-		log.Fatalf("Neither configFile or Dockerfile was specified")
+		return buildConfiguration, fmt.Errorf("neither -f, %v nor -dockerfile was specified", envConfig)
 	}
 
-	// build images
-	if !dryRun {
-		err = BuildImages(buildConfiguration)
-		if err != nil {
-			fmt.Printf("%v\n", err.Error())
-			os.Exit(1)
+	// command line has the final word
+	if len(c.buildArgs) > 0 {
+		buildConfiguration.BuildArgs = c.buildArgs
+	}
+
+	if len(c.backend) > 0 {
+		buildConfiguration.Builder = c.backend
+	}
+
+	buildConfiguration.Since = c.since
+	buildConfiguration.Targets = targets
+	buildConfiguration.Only = c.only
+	buildConfiguration.Downstream = c.downstream
+	buildConfiguration.Incremental = buildConfiguration.Incremental || c.incremental
+	buildConfiguration.Push.Enabled = buildConfiguration.Push.Enabled || c.push
+	if len(c.stateFile) > 0 {
+		buildConfiguration.StateFile = c.stateFile
+	}
+
+	return
+}
+
+// executionFlags are command line flags that control how images are built and how output is printed
+type executionFlags struct {
+	keepGoing bool
+	logDir    string
+	noPrefix  bool
+	color     string
+	quiet     bool
+	threads   int
+}
+
+func (e *executionFlags) register(flags *flag.FlagSet) {
+	flags.BoolVar(&e.keepGoing, "keep-going", false, "Keep building images that don't depend on failed ones")
+	flags.StringVar(&e.logDir, "log-dir", "", "Folder to store build log of every image")
+	flags.BoolVar(&e.noPrefix, "no-prefix", false, "Don't prefix build output with the image name")
+	flags.StringVar(&e.color, "color", "", "Colorize image prefixes: auto, always or never")
+	flags.BoolVar(&e.quiet, "quiet", false, "Don't print build output of images")
+	flags.IntVar(&e.threads, "j", 0, "Number of images to build in parallel, overrides threads from configuration ($"+envThreads+")")
+}
+
+/*
+	This method applies command line flags on top of the configuration, and configures output accordingly
+*/
+func (e *executionFlags) apply(buildConfiguration *BuildConfiguration) error {
+	if value := os.Getenv(envThreads); e.threads == 0 && len(value) > 0 {
+		threads, err := strconv.Atoi(value)
+		if err != nil || threads < 1 {
+			return fmt.Errorf("%v must be a positive number, got [%v]", envThreads, value)
 		}
 
-		// if everything is ok - exit gracefully
-		fmt.Printf("Successfully built %v images\n", buildConfiguration.NumJobs())
-	} else {
-		executable, err := buildExecutableMap(buildConfiguration)
-		if err != nil {
-			fmt.Printf("%v\n", err.Error())
-			os.Exit(1)
+		e.threads = threads
+	}
+
+	buildConfiguration.KeepGoing = buildConfiguration.KeepGoing || e.keepGoing
+	if len(e.logDir) > 0 {
+		buildConfiguration.LogDir = e.logDir
+	}
+
+	if e.threads > 0 {
+		buildConfiguration.Threads = e.threads
+	}
+
+	buildConfiguration.Output.NoPrefix = buildConfiguration.Output.NoPrefix || e.noPrefix
+	buildConfiguration.Output.Quiet = buildConfiguration.Output.Quiet || e.quiet
+	if len(e.color) > 0 {
+		buildConfiguration.Output.Color = e.color
+	}
+
+	return stdout.Configure(buildConfiguration.Output)
+}
+
+/*
+	This function prints result of the build. Returned error carries exit code: build failure is told apart
+	from partial success, and errors that happened before anything was built are left as they are
+*/
+func printOutcome(summary Summary, err error) error {
+	if err != nil {
+		if len(summary.Failed) == 0 && len(summary.Built) == 0 {
+			return err
+		}
+
+		summary.Print(stdout)
+		if len(summary.Built) > 0 {
+			return exitError{code: exitPartial, err: err}
 		}
 
-		fmt.Printf("%v\n", executable)
+		return exitError{code: exitFailure, err: err}
+	}
+
+	// if everything is ok - exit gracefully
+	if len(summary.Unchanged) > 0 {
+		fmt.Printf("Successfully built %v images, %v images are up to date\n", len(summary.Built), len(summary.Unchanged))
+	} else {
+		fmt.Printf("Successfully built %v images\n", len(summary.Built))
+	}
+
+	for _, v := range summary.Pushed {
+		fmt.Printf("Pushed %v (%v)\n", v.ContainerName, v.Digest)
+	}
+
+	return nil
+}
+
+// noArguments returns error if command that takes no positional arguments was given some
+func noArguments(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected argument [%v]", args[0])
 	}
 
-	os.Exit(0)
+	return nil
 }
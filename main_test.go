@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_run(t *testing.T) {
+	resources, err := filepath.Abs("./resources/setup_oneroot")
+	require.NoError(t, err)
+
+	root := writeConfigs(t, map[string]string{
+		"build.yaml": `
+build:
+  - containerName: image1
+    dockerpath: ` + filepath.Join(resources, "Image1") + `
+  - containerName: image2
+    dockerpath: ` + filepath.Join(resources, "Image2") + `
+  - containerName: image3
+    dockerpath: ` + filepath.Join(resources, "Image3") + `
+`,
+		"broken.yaml": `
+build:
+  - containerName: image1
+    dockerpath: ` + filepath.Join(resources, "Image1") + `
+  - containerName: image1:latest
+    dockerpath: ` + filepath.Join(resources, "Image2") + `
+`,
+	})
+
+	// commands configure output of the whole process
+	defer stdout.Configure(OutputConfiguration{})
+
+	config := filepath.Join(root, "build.yaml")
+	broken := filepath.Join(root, "broken.yaml")
+	tests := []struct {
+		name   string
+		script string
+		env    map[string]string
+		args   []string
+		want   int
+	}{
+		{"test_0", "exit 0\n", nil, []string{"build", "-f", config, "-quiet"}, exitSuccess},
+		// flags without command mean build
+		{"test_1", "exit 0\n", nil, []string{"-f", config, "-quiet"}, exitSuccess},
+		{"test_2", "exit 0\n", map[string]string{envConfig: config, envThreads: "2"}, []string{"build", "-quiet"}, exitSuccess},
+		// image2 is the root, nothing can be built without it
+		{"test_3", "test \"$3\" != image2\n", nil, []string{"build", "-f", config, "-quiet", "-keep-going"}, exitFailure},
+		// image3 fails, but image2 and image1 are built
+		{"test_4", "test \"$3\" != image3\n", nil, []string{"build", "-f", config, "-quiet", "-keep-going", "-j", "1"}, exitPartial},
+		{"test_5", "exit 0\n", nil, []string{"build", "-f", broken}, exitConfig},
+		{"test_6", "exit 0\n", map[string]string{envThreads: "many"}, []string{"build", "-f", config}, exitConfig},
+		{"test_7", "exit 0\n", nil, []string{"build"}, exitConfig},
+		{"test_8", "exit 0\n", nil, []string{"build", "-unknown"}, exitConfig},
+		{"test_9", "exit 0\n", nil, []string{"unknown"}, exitConfig},
+		{"test_10", "exit 0\n", nil, []string{}, exitConfig},
+		{"test_11", "exit 0\n", nil, []string{"help", "build"}, exitSuccess},
+		{"test_12", "exit 0\n", nil, []string{"graph", "-h"}, exitSuccess},
+		{"test_13", "exit 0\n", nil, []string{"validate", "-f", broken}, exitConfig},
+		{"test_14", "exit 0\n", nil, []string{"graph", "-f", config, "-o", filepath.Join(root, "graph.dot")}, exitSuccess},
+		{"test_15", "exit 0\n", nil, []string{"graph", "-f", config, "extra"}, exitConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer fakeDocker(t, tt.script)()
+			for k, v := range tt.env {
+				require.NoError(t, os.Setenv(k, v))
+				defer os.Unsetenv(k)
+			}
+
+			require.Equal(t, tt.want, run(tt.args))
+		})
+	}
+}
+
+func Test_cleanCommand(t *testing.T) {
+	resources, err := filepath.Abs("./resources/setup_oneroot")
+	require.NoError(t, err)
+
+	root := writeConfigs(t, map[string]string{
+		"build.yaml": `
+stateFile: ${STATE}
+logDir: ${LOGS}
+build:
+  - containerName: org/api
+    dockerpath: ` + filepath.Join(resources, "Image1") + `
+`,
+		"state.json":                "{}",
+		"logs/org_api-0a484c06.log": "building org/api",
+		"logs/notes.txt":            "written by someone else",
+		"logs/org_web.log":          "log of an image of another configuration",
+		"other/.krane.state":        "{}",
+	})
+
+	state := filepath.Join(root, "state.json")
+	logs := filepath.Join(root, "logs")
+	args := []string{"clean", "-f", filepath.Join(root, "build.yaml"), "-var", "STATE=" + state, "-var", "LOGS=" + logs}
+	require.Equal(t, exitSuccess, run(args))
+	require.NoFileExists(t, state)
+	require.NoFileExists(t, filepath.Join(logs, "org_api-0a484c06.log"))
+
+	// krane didn't write these, so they stay
+	require.FileExists(t, filepath.Join(logs, "notes.txt"))
+	require.FileExists(t, filepath.Join(logs, "org_web.log"))
+
+	// nothing to remove is not an error
+	require.Equal(t, exitSuccess, run(args))
+
+	// without configuration it's unknown which logs are ours
+	require.Equal(t, exitConfig, run([]string{"clean", "-log-dir", logs}))
+	require.FileExists(t, filepath.Join(logs, "notes.txt"))
+
+	other := filepath.Join(root, "other", DefaultStateFile)
+	require.Equal(t, exitSuccess, run([]string{"clean", "-state", other}))
+	require.NoFileExists(t, other)
+}
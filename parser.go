@@ -2,8 +2,9 @@ package main
 
 import (
 	"fmt"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
 )
 
@@ -13,8 +14,7 @@ type NamesMap map[string]Image
 	This method returns specified Dockerfile as string
 */
 func (i Image) Dockerfile() (content string, err error) {
-	// FIXME: remove double // here
-	bytes, err := ioutil.ReadFile(i.Dockerpath + "/Dockerfile")
+	bytes, err := ioutil.ReadFile(i.ResolvedDockerfile())
 	if err == nil {
 		content = string(bytes)
 	}
@@ -35,15 +35,32 @@ func (bc BuildConfiguration) NumJobs() int {
 func (bc BuildConfiguration) NamesMap() (NamesMap, error) {
 	result := make(NamesMap)
 
+	// every tag has to be unique as well
+	_, err := bc.Aliases()
+	if err != nil {
+		return result, err
+	}
+
 	for _, v := range bc.Images {
-		if _, has := result[v.ContainerName]; has {
-			return result, fmt.Errorf("image [%v] is declared more than once", v.ContainerName)
-		}
+		result[normalizeName(v.ContainerName)] = v
+	}
+
+	return result, nil
+}
+
+/*
+	This method maps every name of every image, tags included, to the container name of that image
+*/
+func (bc BuildConfiguration) Aliases() (map[string]string, error) {
+	result := make(map[string]string)
+	for _, v := range bc.Images {
+		primary := normalizeName(v.ContainerName)
+		for _, name := range v.Names() {
+			if _, has := result[name]; has {
+				return result, fmt.Errorf("image [%v] is declared more than once", name)
+			}
 
-		if strings.Contains(v.ContainerName, ":") {
-			result[v.ContainerName] = v
-		} else {
-			result[v.ContainerName+":latest"] = v
+			result[name] = primary
 		}
 	}
 
@@ -56,22 +73,49 @@ This method returns slice of image names
 func (bc BuildConfiguration) Names() (result []string) {
 	SortImages(&bc)
 	for _, v := range bc.Images {
-
-		if strings.Contains(v.ContainerName, ":") {
-			result = append(result, v.ContainerName)
-		} else {
-			result = append(result, v.ContainerName+":latest")
-		}
+		result = append(result, normalizeName(v.ContainerName))
 	}
 
 	return
 }
 
 /*
-	This function provides YAML deserialization of given byte slice
+	This function returns image name with the tag, assuming latest tag if none was given.
+	Registry port, i.e. localhost:5000/image, is not a tag, and images pinned by digest are left as is
+*/
+func normalizeName(name string) string {
+	last := name[strings.LastIndex(name, "/")+1:]
+	if strings.Contains(last, ":") || strings.Contains(last, "@") {
+		return name
+	}
+
+	return name + ":latest"
+}
+
+/*
+	This function provides YAML deserialization of given byte slice. Variables come from environment and vars block only
 */
 func ParseBytes(conf []byte) (bc BuildConfiguration, err error) {
-	err = yaml.Unmarshal(conf, &bc)
+	return parseBytes(conf, variables{}, ".")
+}
+
+// parseBytes interpolates variables within configuration, and deserializes it. Git variables of tags are taken from the given folder
+func parseBytes(conf []byte, vars variables, dir string) (bc BuildConfiguration, err error) {
+	var document yaml.Node
+	err = yaml.Unmarshal(conf, &document)
+	if err != nil || len(document.Content) == 0 {
+		return
+	}
+
+	if errs := interpolateDocument(&document, vars); len(errs) > 0 {
+		return bc, errs
+	}
+
+	err = document.Decode(&bc)
+	if err == nil {
+		err = renderTags(&bc, newTagVariables(dir))
+	}
+
 	if err == nil {
 		SortImages(&bc)
 		for i, _ := range bc.Images {
@@ -94,10 +138,30 @@ func ParseString(conf string) (BuildConfiguration, error) {
 	This function provides deserialization of a given YAML file
 */
 func ParseFile(fileName string) (bc BuildConfiguration, err error) {
+	return ParseFileWithVars(fileName, nil)
+}
+
+/*
+	This function provides deserialization of a given YAML file, with variables given on the command line.
+	Variables are also taken from environment, from .env file next to the configuration file, and from its vars block
+*/
+func ParseFileWithVars(fileName string, overrides map[string]string) (bc BuildConfiguration, err error) {
 	conf, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return
 	}
 
-	return ParseBytes(conf)
+	dotEnv, err := readDotEnv(filepath.Join(filepath.Dir(fileName), dotEnvFile))
+	if err != nil {
+		return
+	}
+
+	bc, err = parseBytes(conf, variables{overrides: overrides, dotEnv: dotEnv}, filepath.Dir(fileName))
+	if errs, ok := err.(ConfigErrors); ok {
+		for i := range errs {
+			errs[i].File = fileName
+		}
+	}
+
+	return
 }
@@ -2,7 +2,7 @@ package main
 
 import (
 	"github.com/stretchr/testify/require"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 	"testing"
 )
 
@@ -54,3 +54,25 @@ func TestParse_File_With_Folders(t *testing.T) {
 	require.Equal(t, conf.Images[0].Folders[0], "alpha:ALPHA")
 	require.Equal(t, conf.Images[0].Folders[1], "beta:BETA")
 }
+
+func TestParse_Booleans(t *testing.T) {
+	// yes, no, on and off aren't booleans in YAML 1.2, but they're still accepted by boolean settings
+	conf, err := ParseString(`
+keepGoing: on
+build:
+  - containerName: Alpha
+    dockerpath: /path/to/Folder
+    noCache: yes
+    pull: Yes
+    push: no
+    buildArgs:
+      DEBUG: yes
+`)
+	require.NoError(t, err)
+	require.True(t, conf.KeepGoing)
+	require.True(t, conf.Images[0].ForbidCache)
+	require.True(t, conf.Images[0].Pull)
+	require.False(t, conf.Images[0].Pushed())
+	require.NotNil(t, conf.Images[0].Push)
+	require.Equal(t, map[string]string{"DEBUG": "yes"}, conf.Images[0].BuildArgs)
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// version of the plan format, plans of other versions are rejected
+const planVersion = 1
+
+// temporaryContext stands for build context of images with folders within commands of the plan. Such images are built
+// in a temporary folder, that gets a copy of the context with folders copied on top, and doesn't exist until the build
+const temporaryContext = "<temporary-context>"
+
+// Plan is a fully resolved build: every image that is going to be built, in order, exactly as it's going to be built
+type Plan struct {
+	Version int `json:"version"`
+
+	// Layers hold names of images that can be built in parallel, in build order
+	Layers [][]string `json:"layers"`
+
+	// Steps are images to build, parents always go before their children
+	Steps []PlanStep `json:"steps"`
+
+	// Unchanged are container names of images that are up to date, so they aren't built
+	Unchanged []string `json:"unchanged,omitempty"`
+
+	// Retag are unchanged images that have tags, tags are added to the existing images instead of rebuilding them
+	Retag []Image `json:"retag,omitempty"`
+
+	// StateFile is where fingerprints of built images go, set in incremental mode only
+	StateFile string `json:"stateFile,omitempty"`
+
+	// Push tells how images are pushed, images themselves say whether they're pushed
+	Push PushConfiguration `json:"push"`
+}
+
+// PlanStep is a single image of the plan
+type PlanStep struct {
+	Name  string `json:"name"`
+	Layer int    `json:"layer"`
+
+	// Dependencies are images of this plan that have to be built before this one
+	Dependencies []string `json:"dependencies"`
+
+	// Image has configuration and command line settings applied already
+	Image Image `json:"image"`
+
+	// Context, Dockerfile and Folders are resolved out of the Image, so they can be reviewed
+	Context    string   `json:"context"`
+	Dockerfile string   `json:"dockerfile"`
+	Folders    []Folder `json:"folders,omitempty"`
+
+	// Command is shown for backends that run command line tools. Images with folders have temporaryContext there
+	Command []string `json:"command,omitempty"`
+
+	// Fingerprint is stored into the state file once the image is built, set in incremental mode only
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+/*
+	This function resolves configuration into a plan: scans dependencies, selects images that have to be built,
+	skips unchanged ones in incremental mode, and applies all settings to the images
+*/
+func makePlan(config BuildConfiguration) (plan Plan, err error) {
+	namesMap, err := config.NamesMap()
+	if err != nil {
+		return
+	}
+
+	_, inDeps, bwdDeps, err := scanDependencies(config)
+	if err != nil {
+		return
+	}
+
+	err = checkCycles(config, inDeps)
+	if err != nil {
+		return
+	}
+
+	queue, err := newScheduler(inDeps, bwdDeps)
+	if err != nil {
+		return
+	}
+
+	backends, err := config.Backends()
+	if err != nil {
+		return
+	}
+
+	// only selected images are built, the rest is considered to be built already
+	selected, err := config.selectImages(namesMap, inDeps, bwdDeps)
+	if err != nil {
+		return
+	}
+
+	// in incremental mode only images that changed since the last build are built
+	fingerprints := make(map[string]string)
+	unchanged := make(map[string]bool)
+	if config.Incremental {
+		var state buildState
+		state, err = loadState(config.StatePath())
+		if err != nil {
+			return
+		}
+
+		fingerprints, unchanged, err = findUnchanged(config, namesMap, queue.order(), inDeps, bwdDeps, backends, state)
+		if err != nil {
+			return
+		}
+
+		plan.StateFile = config.StatePath()
+	}
+
+	plan.Version = planVersion
+	plan.Push = config.Push
+	layers := make(map[string]int)
+	for _, name := range queue.order() {
+		if selected != nil && !selected[name] {
+			continue
+		}
+
+		if unchanged[name] {
+			plan.Unchanged = append(plan.Unchanged, namesMap[name].ContainerName)
+			if len(namesMap[name].Tags) > 0 {
+				plan.Retag = append(plan.Retag, config.resolve(namesMap[name]))
+			}
+			continue
+		}
+
+		step, err := newPlanStep(config, namesMap[name], fingerprints[name], backends)
+		if err != nil {
+			return plan, err
+		}
+
+		// parents that aren't part of the plan are considered to be built already
+		step.Dependencies = []string{}
+		for _, dep := range inDeps[name] {
+			if layer, has := layers[dep]; has {
+				step.Dependencies = append(step.Dependencies, dep)
+				if layer+1 > step.Layer {
+					step.Layer = layer + 1
+				}
+			}
+		}
+
+		sort.Strings(step.Dependencies)
+		layers[name] = step.Layer
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	// keep plan stable: by layer, and by name within every layer
+	sort.Strings(plan.Unchanged)
+	sort.Slice(plan.Retag, func(i, j int) bool {
+		return plan.Retag[i].ContainerName < plan.Retag[j].ContainerName
+	})
+	sort.SliceStable(plan.Steps, func(i, j int) bool {
+		if plan.Steps[i].Layer != plan.Steps[j].Layer {
+			return plan.Steps[i].Layer < plan.Steps[j].Layer
+		}
+
+		return plan.Steps[i].Name < plan.Steps[j].Name
+	})
+
+	plan.Layers = [][]string{}
+	for _, step := range plan.Steps {
+		if step.Layer == len(plan.Layers) {
+			plan.Layers = append(plan.Layers, []string{})
+		}
+
+		plan.Layers[step.Layer] = append(plan.Layers[step.Layer], step.Name)
+	}
+
+	return
+}
+
+// newPlanStep resolves a single image of the configuration. Fingerprint, if any, goes to the image labels
+func newPlanStep(config BuildConfiguration, image Image, fingerprint string, backends map[string]Builder) (step PlanStep, err error) {
+	step.Name = normalizeName(image.ContainerName)
+	step.Image = config.resolve(image)
+	step.Fingerprint = fingerprint
+	if len(fingerprint) > 0 {
+		step.Image.Labels = withLabel(step.Image.Labels, fingerprintLabel, fingerprint)
+	}
+
+	step.Context = step.Image.BuildContext()
+	step.Dockerfile = step.Image.ResolvedDockerfile()
+
+	for _, v := range image.Folders {
+		var folder Folder
+		folder, err = NewFolder(v)
+		if err != nil {
+			return
+		}
+
+		step.Folders = append(step.Folders, folder)
+	}
+
+	if commander, ok := backends[step.Image.Builder].(commander); ok {
+		buildPath := step.Context
+		if len(step.Folders) > 0 {
+			buildPath = temporaryContext
+		}
+
+		step.Command = commander.Command(step.Image, buildPath)
+	}
+
+	return
+}
+
+/*
+	This function reads plan from a given file
+*/
+func loadPlan(fileName string) (plan Plan, err error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(content, &plan)
+	if err != nil {
+		return plan, fmt.Errorf("wrong plan [%v]: %v", fileName, err)
+	}
+
+	if plan.Version != planVersion {
+		return plan, fmt.Errorf("plan [%v] has version %v, expected %v", fileName, plan.Version, planVersion)
+	}
+
+	return
+}
+
+/*
+	This method writes plan as indented JSON
+*/
+func (p Plan) Write(w io.Writer) error {
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
+
+/*
+	This method prints plan in human readable form: layer by layer, with exact commands where backend has them
+*/
+func (p Plan) Describe(w io.Writer) {
+	for i, layer := range p.Layers {
+		_, _ = fmt.Fprintf(w, "Layer %v:\n", i)
+		for _, name := range layer {
+			step := p.step(name)
+			if len(step.Command) > 0 {
+				_, _ = fmt.Fprintf(w, "  %v\n", strings.Join(step.Command, " "))
+			} else {
+				_, _ = fmt.Fprintf(w, "  %v: %v\n", step.Image.Builder, step.Image.ContainerName)
+			}
+
+			// folders are copied into the temporary copy of the context before the build
+			if len(step.Folders) > 0 {
+				var folders []string
+				for _, v := range step.Folders {
+					folders = append(folders, v.Source+" to "+v.Target)
+				}
+
+				_, _ = fmt.Fprintf(w, "    %v is a copy of %v, with %v\n", temporaryContext, step.Context, strings.Join(folders, ", "))
+			}
+		}
+	}
+
+	for _, v := range p.Unchanged {
+		_, _ = fmt.Fprintf(w, "Up to date: %v\n", v)
+	}
+
+	for _, v := range p.Retag {
+		_, _ = fmt.Fprintf(w, "Tag: %v as %v\n", v.ContainerName, strings.Join(v.Tags, ", "))
+	}
+}
+
+// stepImages returns images of all steps
+func (p Plan) stepImages() (images []Image) {
+	for _, v := range p.Steps {
+		images = append(images, v.Image)
+	}
+
+	return
+}
+
+// step returns step with a given name
+func (p Plan) step(name string) PlanStep {
+	for _, v := range p.Steps {
+		if v.Name == name {
+			return v
+		}
+	}
+
+	return PlanStep{Name: name}
+}
+
+/*
+	This function builds images of the plan, exactly as they're given there. Every image is dispatched as soon as all of its
+	dependencies were built. Threads, KeepGoing and LogDir are taken from config, everything else comes from the plan
+*/
+func applyPlan(plan Plan, config BuildConfiguration) (summary Summary, err error) {
+	// make sure we use some threads
+	if config.Threads < 1 {
+		config.Threads = runtime.NumCPU()
+	}
+
+	inDeps := make(Dependencies)
+	bwdDeps := make(Dependencies)
+	steps := make(map[string]PlanStep)
+	backends := make(map[string]Builder)
+	for _, step := range plan.Steps {
+		steps[step.Name] = step
+		inDeps[step.Name] = step.Dependencies
+		for _, dep := range step.Dependencies {
+			bwdDeps[dep] = append(bwdDeps[dep], step.Name)
+		}
+	}
+
+	for _, image := range append(plan.stepImages(), plan.Retag...) {
+		if _, has := backends[image.Builder]; !has {
+			backends[image.Builder], err = newBuilder(image.Builder)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	for _, step := range plan.Steps {
+		for _, dep := range step.Dependencies {
+			if _, has := steps[dep]; !has {
+				return summary, fmt.Errorf("image [%v] depends on [%v], which is not a part of the plan", step.Name, dep)
+			}
+		}
+	}
+
+	queue, err := newScheduler(inDeps, bwdDeps)
+	if err != nil {
+		return
+	}
+
+	// make sure images can be pushed before building anything
+	for _, image := range append(plan.stepImages(), plan.Retag...) {
+		if _, ok := backends[image.Builder].(pusher); image.Pushed() && !ok {
+			return summary, fmt.Errorf("image [%v] has to be pushed, but builder [%v] can't push images", image.ContainerName, image.Builder)
+		}
+	}
+
+	for _, image := range plan.Retag {
+		if _, ok := backends[image.Builder].(tagger); !ok {
+			return summary, fmt.Errorf("image [%v] has to be tagged, but builder [%v] can't tag images", image.ContainerName, image.Builder)
+		}
+	}
+
+	if len(config.LogDir) > 0 {
+		err = os.MkdirAll(config.LogDir, 0755)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, v := range plan.Unchanged {
+		summary.Unchanged = append(summary.Unchanged, Report{ContainerName: v, Success: true})
+	}
+
+	// unchanged images exist already, so they're tagged before anything is built, and pushed along with the built ones
+	var retagged []Image
+	for _, image := range plan.Retag {
+		if report := tagImage(image, backends[image.Builder]); !report.Success {
+			summary.Failed = append(summary.Failed, report)
+		} else if image.Pushed() {
+			retagged = append(retagged, image)
+		}
+	}
+
+	// in incremental mode, remember fingerprints of the images that were built, whatever happens next.
+	// images that have to be pushed are remembered only once they're pushed, so failed pushes are retried next time
+	if len(plan.StateFile) > 0 {
+		var state buildState
+		state, err = loadState(plan.StateFile)
+		if err != nil {
+			return
+		}
+
+		defer func() {
+			pushed := make(map[string]bool)
+			for _, v := range summary.Pushed {
+				pushed[normalizeName(v.ContainerName)] = true
+			}
+
+			for _, v := range summary.Built {
+				step := steps[normalizeName(v.ContainerName)]
+				if step.Image.Pushed() && !pushed[step.Name] {
+					continue
+				}
+
+				state.Images[step.Name] = step.Fingerprint
+			}
+
+			if stateErr := saveState(plan.StateFile, state); stateErr != nil && err == nil {
+				err = stateErr
+			}
+		}()
+	}
+
+	// reports queue. so we'll know the outcome of every build
+	requeue := make(chan Report, len(plan.Steps))
+
+	// now, let's build some workers which will do the actual job
+	jobs := make(chan Image)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Threads; i++ {
+		wg.Add(1)
+		go worker(jobs, backends, config.LogDir, requeue, &wg)
+	}
+
+	// make sure workers are gone once we're done here
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	// pushes have their own workers, so they don't hold builds. push queue is large enough to never block
+	pushThreads := plan.Push.Threads
+	if pushThreads < 1 {
+		pushThreads = defaultPushThreads
+	}
+
+	pushJobs := make(chan Image, len(plan.Steps)+len(plan.Retag))
+	pushReports := make(chan Report, len(plan.Steps)+len(plan.Retag))
+	var pushWg sync.WaitGroup
+	for i := 0; i < pushThreads; i++ {
+		pushWg.Add(1)
+		go pushWorker(pushJobs, backends, plan.Push.Retries, pushReports, &pushWg)
+	}
+
+	var delayed []Image
+	for _, image := range retagged {
+		if plan.Push.AfterAll {
+			delayed = append(delayed, image)
+		} else {
+			pushJobs <- image
+		}
+	}
+
+	// dispatch jobs as soon as they're ready. there's never more than config.Threads jobs in flight,
+	// so there's always an idle worker to take the next one
+	inFlight := 0
+	for {
+		for (config.KeepGoing || len(summary.Failed) == 0) && inFlight < config.Threads {
+			name, ok := queue.next()
+			if !ok {
+				break
+			}
+
+			jobs <- steps[name].Image
+			inFlight++
+		}
+
+		// nothing is running and nothing can be dispatched, we're done here
+		if inFlight == 0 {
+			break
+		}
+
+		report := <-requeue
+		inFlight--
+		if !report.Success {
+			summary.Failed = append(summary.Failed, report)
+
+			// everything downstream of the failed image won't be built
+			for _, v := range queue.fail(normalizeName(report.ContainerName)) {
+				summary.Skipped = append(summary.Skipped, Report{
+					ContainerName: steps[v].Image.ContainerName,
+					Error:         fmt.Errorf("parent image [%v] failed", report.ContainerName),
+				})
+			}
+		} else {
+			summary.Built = append(summary.Built, report)
+			queue.complete(normalizeName(report.ContainerName))
+
+			// image is pushed right away, unless we have to wait for the whole graph
+			if image := steps[normalizeName(report.ContainerName)].Image; image.Pushed() {
+				if plan.Push.AfterAll {
+					delayed = append(delayed, image)
+				} else {
+					pushJobs <- image
+				}
+			}
+		}
+	}
+
+	if len(summary.Failed) == 0 {
+		for _, image := range delayed {
+			pushJobs <- image
+		}
+	}
+
+	// wait for all pushes to finish
+	close(pushJobs)
+	pushWg.Wait()
+	close(pushReports)
+	for report := range pushReports {
+		if report.Success {
+			summary.Pushed = append(summary.Pushed, report)
+		} else {
+			summary.Failed = append(summary.Failed, report)
+		}
+	}
+
+	if len(summary.Failed) > 0 {
+		if config.KeepGoing {
+			return summary, fmt.Errorf("%v out of %v jobs failed, %v skipped", len(summary.Failed), len(plan.Steps), len(summary.Skipped))
+		}
+
+		return summary, fmt.Errorf("At least %v out of %v jobs failed", len(summary.Failed), len(plan.Steps))
+	}
+
+	// looks like we're all good
+	return
+}
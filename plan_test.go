@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_makePlan(t *testing.T) {
+	config := oneRootConfig()
+	config.Images[0].BuildArgs = map[string]string{"VERSION": "1"}
+	config.Images[0].Folders = []string{"./resources/setup_nodeps:files"}
+	config.Builder = "docker"
+	config.BuildArgs = map[string]string{"VERSION": "2"}
+
+	plan, err := makePlan(config)
+	require.NoError(t, err)
+	require.Equal(t, planVersion, plan.Version)
+	require.Equal(t, [][]string{{"image2:latest"}, {"image1:latest", "image3:latest"}}, plan.Layers)
+	require.Len(t, plan.Steps, 3)
+
+	step := plan.Steps[1]
+	require.Equal(t, "image1:latest", step.Name)
+	require.Equal(t, 1, step.Layer)
+	require.Equal(t, []string{"image2:latest"}, step.Dependencies)
+	require.Equal(t, map[string]string{"VERSION": "2"}, step.Image.BuildArgs)
+	require.Equal(t, "docker", step.Image.Builder)
+	require.Equal(t, "./resources/setup_oneroot/Image1", step.Context)
+	require.Equal(t, "resources/setup_oneroot/Image1/Dockerfile", step.Dockerfile)
+	require.Equal(t, []Folder{{Source: "./resources/setup_nodeps", Target: "files"}}, step.Folders)
+	require.Equal(t, []string{"docker", "build", "--build-arg", "VERSION=2", "-f", "resources/setup_oneroot/Image1/Dockerfile", "-t", "image1", temporaryContext}, step.Command)
+
+	// images without folders are built right from their context
+	require.Equal(t, []string{"docker", "build", "--build-arg", "VERSION=2", "-t", "image2", "./resources/setup_oneroot/Image2"}, plan.Steps[0].Command)
+}
+
+func Test_makePlan_Targets(t *testing.T) {
+	config := oneRootConfig()
+	config.Builder = "docker"
+	config.Targets = []string{"image1"}
+	config.Only = true
+
+	plan, err := makePlan(config)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"image1:latest"}}, plan.Layers)
+
+	// image2 isn't a part of the plan, so it's considered to be built already
+	require.Equal(t, []string{}, plan.Steps[0].Dependencies)
+	require.Equal(t, 0, plan.Steps[0].Layer)
+}
+
+func TestPlan_Describe(t *testing.T) {
+	config := oneRootConfig()
+	config.Builder = "docker"
+	config.Images[2].Builder = "engine"
+	config.Images[0].Folders = []string{"./resources/setup_nodeps:files"}
+
+	plan, err := makePlan(config)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	plan.Describe(&output)
+	require.Equal(t, "Layer 0:\n"+
+		"  docker build -t image2 ./resources/setup_oneroot/Image2\n"+
+		"Layer 1:\n"+
+		"  docker build -f resources/setup_oneroot/Image1/Dockerfile -t image1 <temporary-context>\n"+
+		"    <temporary-context> is a copy of ./resources/setup_oneroot/Image1, with ./resources/setup_nodeps to files\n"+
+		"  engine: image3\n", output.String())
+}
+
+func Test_applyPlan(t *testing.T) {
+	backend := &fakeBuilder{failing: map[string]bool{"image2": true}}
+	defer useFakeBuilder(backend)()
+
+	plan, err := makePlan(oneRootConfig())
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "krane-plan")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var content bytes.Buffer
+	require.NoError(t, plan.Write(&content))
+	fileName := filepath.Join(dir, "plan.json")
+	require.NoError(t, os.WriteFile(fileName, content.Bytes(), 0644))
+
+	loaded, err := loadPlan(fileName)
+	require.NoError(t, err)
+	require.Equal(t, plan, loaded)
+
+	// plan is applied as is, image1 is built although its Dockerfile is gone, and image2 goes first anyway
+	loaded.Steps[1].Image.Dockerpath = filepath.Join(dir, "missing")
+	loaded.Steps[1].Dependencies = []string{}
+	summary, err := applyPlan(loaded, BuildConfiguration{Threads: 1, KeepGoing: true})
+	require.EqualError(t, err, "1 out of 3 jobs failed, 1 skipped")
+	require.Equal(t, []string{"image2", "image1"}, append([]string{summary.Failed[0].ContainerName}, backend.built...))
+	require.Equal(t, "image3", summary.Skipped[0].ContainerName)
+}
+
+func Test_loadPlan_Version(t *testing.T) {
+	dir, err := os.MkdirTemp("", "krane-plan")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "plan.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`{"version": 2}`), 0644))
+
+	_, err = loadPlan(fileName)
+	require.Error(t, err)
+}
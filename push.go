@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// number of images pushed in parallel, if configuration doesn't say otherwise
+const defaultPushThreads = 2
+
+// delay before the first retry of a failed push, every next retry waits longer
+var pushRetryDelay = 2 * time.Second
+
+/*
+	This function pushes all images that come from input channel, until the channel is closed.
+	Every image is pushed by the backend that built it
+*/
+func pushWorker(input <-chan Image, backends map[string]Builder, retries int, output chan<- Report, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for image := range input {
+		output <- pushImage(image, backends[image.Builder], retries)
+	}
+}
+
+/*
+	This function adds tags to the image that is up to date already, instead of rebuilding it
+*/
+func tagImage(image Image, backend Builder) (report Report) {
+	report.ContainerName = image.ContainerName
+
+	t, ok := backend.(tagger)
+	if !ok {
+		report.Error = fmt.Errorf("builder [%v] can't tag images", image.Builder)
+		return
+	}
+
+	var output bytes.Buffer
+	_, report.Error = runLogged(image.ContainerName, &output, func(w io.Writer) (string, error) {
+		return "", t.Tag(image, w)
+	})
+
+	if report.Error != nil {
+		report.Error = fmt.Errorf("tagging failed: %v", report.Error)
+	}
+
+	report.Log = output.String()
+	report.Success = report.Error == nil
+	return
+}
+
+/*
+	This function pushes a single image, retrying failed pushes up to a given number of times.
+	Digest of the pushed image goes to the report
+*/
+func pushImage(image Image, backend Builder, retries int) (report Report) {
+	report.ContainerName = image.ContainerName
+
+	p, ok := backend.(pusher)
+	if !ok {
+		report.Error = fmt.Errorf("builder [%v] can't push images", image.Builder)
+		return
+	}
+
+	var output bytes.Buffer
+	for attempt := 0; ; attempt++ {
+		report.Digest, report.Error = runLogged(image.ContainerName, &output, func(w io.Writer) (string, error) {
+			return p.Push(image, w)
+		})
+
+		if report.Error == nil || attempt >= retries {
+			break
+		}
+
+		_ = stdout.PrintlnFrom(image.ContainerName, fmt.Sprintf("push failed, retrying: %v", report.Error))
+		time.Sleep(pushRetryDelay * time.Duration(attempt+1))
+	}
+
+	if report.Error != nil {
+		report.Error = fmt.Errorf("push failed: %v", report.Error)
+	}
+
+	report.Log = output.String()
+	report.Success = report.Error == nil
+	return
+}
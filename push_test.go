@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildImages_Push(t *testing.T) {
+	backend := &fakeBuilder{}
+	defer useFakeBuilder(backend)()
+
+	config := oneRootConfig()
+	config.Push.Enabled = true
+	noPush := false
+	config.Images[2].Push = &noPush
+
+	summary, err := BuildImages(config)
+	require.NoError(t, err)
+
+	sort.Strings(backend.pushed)
+	require.Equal(t, []string{"image1", "image2"}, backend.pushed)
+	require.Len(t, summary.Pushed, 2)
+	for _, v := range summary.Pushed {
+		require.Equal(t, "sha256:pushed-"+v.ContainerName, v.Digest)
+	}
+}
+
+func TestBuildImages_PushRetries(t *testing.T) {
+	defer func(delay time.Duration) { pushRetryDelay = delay }(pushRetryDelay)
+	pushRetryDelay = 0
+
+	backend := &fakeBuilder{pushFailures: map[string]int{"image2": 2}}
+	defer useFakeBuilder(backend)()
+
+	config := oneRootConfig()
+	config.Push.Enabled = true
+	config.Push.Retries = 1
+
+	// two failures are one too many
+	summary, err := BuildImages(config)
+	require.Error(t, err)
+	require.Len(t, summary.Built, 3)
+	require.Len(t, summary.Pushed, 2)
+	require.Equal(t, "image2", summary.Failed[0].ContainerName)
+	require.EqualError(t, summary.Failed[0].Error, "push failed: registry is not available")
+
+	// the last failure is left
+	backend.pushFailures["image2"] = 1
+	summary, err = BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, summary.Pushed, 3)
+}
+
+func TestBuildImages_PushAfterAll(t *testing.T) {
+	backend := &fakeBuilder{failing: map[string]bool{"image3": true}}
+	defer useFakeBuilder(backend)()
+
+	config := oneRootConfig()
+	config.KeepGoing = true
+	config.Push.Enabled = true
+
+	// without afterAll, images are pushed as soon as they're built
+	_, err := BuildImages(config)
+	require.Error(t, err)
+	sort.Strings(backend.pushed)
+	require.Equal(t, []string{"image1", "image2"}, backend.pushed)
+
+	// with afterAll nothing is pushed, because image3 failed
+	backend.pushed = nil
+	config.Push.AfterAll = true
+	summary, err := BuildImages(config)
+	require.Error(t, err)
+	require.Empty(t, backend.pushed)
+	require.Empty(t, summary.Pushed)
+}
+
+func TestBuildImages_IncrementalPushFailure(t *testing.T) {
+	backend := &fakeBuilder{pushFailures: map[string]int{"tool": 1}}
+	defer useFakeBuilder(backend)()
+
+	root := t.TempDir()
+	config := BuildConfiguration{
+		Images:      []Image{{ContainerName: "app", Dockerpath: writeImage(t, root, "app", "FROM alpine")}, {ContainerName: "tool", Dockerpath: writeImage(t, root, "tool", "FROM alpine")}},
+		Builder:     "fake",
+		Incremental: true,
+		StateFile:   path.Join(root, "state.json"),
+	}
+	config.Push.Enabled = true
+
+	summary, err := BuildImages(config)
+	require.Error(t, err)
+	require.Len(t, summary.Built, 2)
+	require.Equal(t, "tool", summary.Failed[0].ContainerName)
+
+	// image that wasn't pushed is built and pushed again, the pushed one is up to date
+	backend.built = nil
+	backend.pushed = nil
+	summary, err = BuildImages(config)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tool"}, backend.built)
+	require.Equal(t, []string{"tool"}, backend.pushed)
+	require.Len(t, summary.Unchanged, 1)
+}
+
+func TestBuildImages_PushRetagged(t *testing.T) {
+	backend := &fakeBuilder{}
+	defer useFakeBuilder(backend)()
+
+	root := t.TempDir()
+	config := BuildConfiguration{
+		Images:      []Image{{ContainerName: "app", Dockerpath: writeImage(t, root, "app", "FROM alpine")}},
+		Builder:     "fake",
+		Incremental: true,
+		StateFile:   path.Join(root, "state.json"),
+	}
+	config.Push.Enabled = true
+
+	_, err := BuildImages(config)
+	require.NoError(t, err)
+
+	// image is up to date, but its new tag has to reach the registry anyway
+	backend.built = nil
+	backend.pushed = nil
+	config.Images[0].Tags = []string{"app:1.0"}
+	summary, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Empty(t, backend.built)
+	require.Equal(t, []string{"app:1.0"}, backend.tagged)
+	require.Equal(t, []string{"app"}, backend.pushed)
+	require.Len(t, summary.Pushed, 1)
+}
+
+func TestCliBuilder_Push(t *testing.T) {
+	defer fakeDocker(t, "echo \"pushing $2\"\necho \"latest: digest: sha256:0123456789012345678901234567890123456789012345678901234567890123 size: 528\"\n")()
+
+	backend, err := newBuilder("docker")
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	digest, err := backend.(pusher).Push(Image{ContainerName: "org/api"}, &output)
+	require.NoError(t, err)
+	require.Equal(t, "sha256:0123456789012345678901234567890123456789012345678901234567890123", digest)
+	require.Contains(t, output.String(), "pushing org/api\n")
+}
+
+func TestCliBuilder_Tag(t *testing.T) {
+	defer fakeDocker(t, "echo \"$@\"\n")()
+
+	backend, err := newBuilder("docker")
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	err = backend.(tagger).Tag(Image{ContainerName: "org/api", Tags: []string{"org/api:1.0", "org/api:sha-abc"}}, &output)
+	require.NoError(t, err)
+	require.Equal(t, "tag org/api org/api:1.0\ntag org/api org/api:sha-abc\n", output.String())
+}
+
+func Test_parseDigest(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"test_0", "", ""},
+		{"test_1", "The push refers to repository [docker.io/org/api]\nlatest: digest: sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa size: 528\n", "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{"test_2", "digest: sha256:short\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parseDigest(tt.output))
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+	scheduler keeps track of the images that are ready to be built. An image becomes ready
+	as soon as all of its internal dependencies were built, regardless of the layer it belongs to
+*/
+type scheduler struct {
+	// number of internal dependencies, that weren't built yet, per image
+	pending map[string]int
+
+	// backward dependencies, so we know whom to notify once image is built
+	bwd Dependencies
+
+	// images that are ready to be dispatched
+	ready []string
+
+	// images that will never be built, because one of their parents failed
+	skipped map[string]bool
+}
+
+/*
+	This function creates new scheduler out of internal and backward dependencies produced by scanDependencies
+*/
+func newScheduler(inDeps, bwd Dependencies) (s *scheduler, err error) {
+	s = &scheduler{
+		pending: make(map[string]int),
+		bwd:     bwd,
+		skipped: make(map[string]bool),
+	}
+
+	for k, deps := range inDeps {
+		s.pending[k] = len(deps)
+		if len(deps) == 0 {
+			s.ready = append(s.ready, k)
+		}
+	}
+
+	// keep dispatch order stable
+	sort.Strings(s.ready)
+
+	// make sure the graph can be fully built before dispatching anything
+	if len(s.order()) != len(s.pending) {
+		err = fmt.Errorf("wasn't able to sort the graph")
+	}
+
+	return
+}
+
+/*
+	This method returns images in topological order, parents always go before their children.
+	Images that will never become ready are not included
+*/
+func (s *scheduler) order() (result []string) {
+	pending := make(map[string]int)
+	for k, v := range s.pending {
+		pending[k] = v
+	}
+
+	queue := append([]string{}, s.ready...)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		result = append(result, current)
+
+		for _, child := range s.bwd[current] {
+			pending[child]--
+			if pending[child] == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	return
+}
+
+/*
+	This method returns next image that is ready to be built, if any
+*/
+func (s *scheduler) next() (name string, ok bool) {
+	if len(s.ready) == 0 {
+		return
+	}
+
+	name = s.ready[0]
+	s.ready = s.ready[1:]
+	return name, true
+}
+
+/*
+	This method marks given image as built, and makes its dependants ready if they have nothing else to wait for
+*/
+func (s *scheduler) complete(name string) {
+	var unlocked []string
+	for _, child := range s.bwd[name] {
+		s.pending[child]--
+		if s.pending[child] == 0 {
+			unlocked = append(unlocked, child)
+		}
+	}
+
+	sort.Strings(unlocked)
+	s.ready = append(s.ready, unlocked...)
+}
+
+/*
+	This method marks given image as failed, and returns all of its descendants that have to be skipped because of that
+*/
+func (s *scheduler) fail(name string) (skipped []string) {
+	queue := append([]string{}, s.bwd[name]...)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if s.skipped[current] {
+			continue
+		}
+
+		s.skipped[current] = true
+		skipped = append(skipped, current)
+		queue = append(queue, s.bwd[current]...)
+	}
+
+	sort.Strings(skipped)
+	return
+}
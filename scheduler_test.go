@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func drain(s *scheduler) (result []string) {
+	for {
+		name, ok := s.next()
+		if !ok {
+			return
+		}
+
+		result = append(result, name)
+	}
+}
+
+func TestScheduler_Streaming(t *testing.T) {
+	// a -> b, c -> d, a and c are independent roots
+	inDeps := Dependencies{"a:latest": {}, "b:latest": {"a:latest"}, "c:latest": {}, "d:latest": {"c:latest"}}
+	bwdDeps := Dependencies{"a:latest": {"b:latest"}, "b:latest": {}, "c:latest": {"d:latest"}, "d:latest": {}}
+
+	s, err := newScheduler(inDeps, bwdDeps)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a:latest", "c:latest"}, drain(s))
+
+	// d must become ready right away, without waiting for a to finish
+	s.complete("c:latest")
+	require.Equal(t, []string{"d:latest"}, drain(s))
+
+	s.complete("a:latest")
+	require.Equal(t, []string{"b:latest"}, drain(s))
+}
+
+func TestScheduler_MultipleParents(t *testing.T) {
+	inDeps := Dependencies{"a:latest": {}, "b:latest": {}, "c:latest": {"a:latest", "b:latest"}}
+	bwdDeps := Dependencies{"a:latest": {"c:latest"}, "b:latest": {"c:latest"}, "c:latest": {}}
+
+	s, err := newScheduler(inDeps, bwdDeps)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a:latest", "b:latest"}, drain(s))
+
+	s.complete("a:latest")
+	require.Empty(t, drain(s))
+
+	s.complete("b:latest")
+	require.Equal(t, []string{"c:latest"}, drain(s))
+}
+
+func TestScheduler_Cycle(t *testing.T) {
+	inDeps := Dependencies{"a:latest": {"b:latest"}, "b:latest": {"a:latest"}, "c:latest": {}}
+	bwdDeps := Dependencies{"a:latest": {"b:latest"}, "b:latest": {"a:latest"}, "c:latest": {}}
+
+	_, err := newScheduler(inDeps, bwdDeps)
+	require.Error(t, err)
+}
+
+func TestScheduler_Fail(t *testing.T) {
+	// a -> b -> c, a -> d, e is independent
+	inDeps := Dependencies{"a:latest": {}, "b:latest": {"a:latest"}, "c:latest": {"b:latest"}, "d:latest": {"a:latest"}, "e:latest": {}}
+	bwdDeps := Dependencies{"a:latest": {"b:latest", "d:latest"}, "b:latest": {"c:latest"}, "c:latest": {}, "d:latest": {}, "e:latest": {}}
+
+	s, err := newScheduler(inDeps, bwdDeps)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a:latest", "e:latest"}, drain(s))
+
+	require.Equal(t, []string{"b:latest", "c:latest", "d:latest"}, s.fail("a:latest"))
+
+	s.complete("e:latest")
+	require.Empty(t, drain(s))
+}
+
+func TestScheduler_Fail_SharedDescendant(t *testing.T) {
+	inDeps := Dependencies{"a:latest": {}, "b:latest": {}, "c:latest": {"a:latest", "b:latest"}}
+	bwdDeps := Dependencies{"a:latest": {"c:latest"}, "b:latest": {"c:latest"}, "c:latest": {}}
+
+	s, err := newScheduler(inDeps, bwdDeps)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"c:latest"}, s.fail("a:latest"))
+	require.Empty(t, s.fail("b:latest"))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	This method returns images that have to be built in this run, or nil if every image has to be built.
+	With Since set, only images affected by files changed since that git ref are selected, along with everything downstream.
+	With Targets set, only images matching them are selected, along with their ancestors (unless Only is set),
+	and their descendants (if Downstream is set). If both are set, image has to be selected by both
+*/
+func (bc BuildConfiguration) selectImages(namesMap NamesMap, inDeps, bwdDeps Dependencies) (selected map[string]bool, err error) {
+	if len(bc.Since) > 0 {
+		selected, err = bc.selectChanged(namesMap, bwdDeps)
+		if err != nil {
+			return
+		}
+	}
+
+	if len(bc.Targets) > 0 {
+		var targets map[string]bool
+		targets, err = bc.selectTargets(namesMap, inDeps, bwdDeps)
+		if err != nil {
+			return
+		}
+
+		if selected == nil {
+			return targets, nil
+		}
+
+		for k := range selected {
+			if !targets[k] {
+				delete(selected, k)
+			}
+		}
+	}
+
+	return
+}
+
+// selectChanged returns images affected by files changed since bc.Since, and their descendants. Git repository is the one configuration is in
+func (bc BuildConfiguration) selectChanged(namesMap NamesMap, bwdDeps Dependencies) (selected map[string]bool, err error) {
+	dir := bc.Dir
+	if len(dir) == 0 {
+		dir = "."
+	}
+
+	files, err := changedFiles(dir, bc.Since)
+	if err != nil {
+		return
+	}
+
+	selected = make(map[string]bool)
+	for name, image := range namesMap {
+		var owns bool
+		owns, err = ownsAnyFile(image, files)
+		if err != nil {
+			return
+		}
+
+		if owns {
+			selected[name] = true
+		}
+	}
+
+	return withReachable(selected, bwdDeps), nil
+}
+
+// selectTargets returns images matching bc.Targets, with their ancestors and descendants as requested
+func (bc BuildConfiguration) selectTargets(namesMap NamesMap, inDeps, bwdDeps Dependencies) (selected map[string]bool, err error) {
+	selected = make(map[string]bool)
+	for _, pattern := range bc.Targets {
+		matched := false
+		for name, image := range namesMap {
+			var ok bool
+			ok, err = matchTarget(pattern, image)
+			if err != nil {
+				return
+			}
+
+			if ok {
+				selected[name] = true
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("no image matches [%v]", pattern)
+		}
+	}
+
+	if !bc.Only {
+		selected = withReachable(selected, inDeps)
+	}
+
+	if bc.Downstream {
+		selected = withReachable(selected, bwdDeps)
+	}
+
+	return
+}
+
+// matchTarget checks if target, either image name or glob pattern, matches given image. Any tag of the image can be matched
+func matchTarget(pattern string, image Image) (bool, error) {
+	names := append(image.References(), image.Names()...)
+	for _, v := range image.Names() {
+		if normalizeName(pattern) == v {
+			return true, nil
+		}
+	}
+
+	for _, v := range names {
+		matched, err := path.Match(pattern, v)
+		if err != nil {
+			return false, fmt.Errorf("wrong target pattern [%v]: %v", pattern, err)
+		}
+
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// withReachable adds everything reachable from the given images over given dependencies to the set. Backward dependencies
+// give descendants of the images, forward ones give their ancestors
+func withReachable(images map[string]bool, deps Dependencies) map[string]bool {
+	var queue []string
+	for k := range images {
+		queue = append(queue, k)
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, v := range deps[current] {
+			if !images[v] {
+				images[v] = true
+				queue = append(queue, v)
+			}
+		}
+	}
+
+	return images
+}
+
+// ownsAnyFile checks if any of given absolute file names belongs to the image: its build context, Dockerfile or folders
+func ownsAnyFile(image Image, files []string) (owns bool, err error) {
+	roots := []string{image.Dockerpath, image.BuildContext(), image.ResolvedDockerfile()}
+	for _, v := range image.Folders {
+		var folder Folder
+		folder, err = NewFolder(v)
+		if err != nil {
+			return
+		}
+
+		roots = append(roots, folder.Source)
+	}
+
+	for _, root := range roots {
+		root, err = realPath(root)
+		if err != nil {
+			return
+		}
+
+		for _, file := range files {
+			if file == root || strings.HasPrefix(file, root+string(filepath.Separator)) {
+				return true, nil
+			}
+		}
+	}
+
+	return
+}
+
+/*
+	This function returns absolute names of files changed since the given git ref: committed, uncommitted and untracked ones.
+	Changes are taken since the point where current branch forked from ref, so changes made on ref itself don't count.
+	dir is any folder within git repository
+*/
+func changedFiles(dir string, ref string) (files []string, err error) {
+	root, err := git("-C", dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return
+	}
+
+	root = strings.TrimSpace(root)
+	base, err := git("-C", root, "merge-base", ref, "HEAD")
+	if err != nil {
+		return
+	}
+
+	changed, err := git("-C", root, "diff", "--name-only", strings.TrimSpace(base))
+	if err != nil {
+		return
+	}
+
+	untracked, err := git("-C", root, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return
+	}
+
+	for _, v := range strings.Split(changed+"\n"+untracked, "\n") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			files = append(files, filepath.Join(root, filepath.FromSlash(v)))
+		}
+	}
+
+	return
+}
+
+// realPath returns absolute path with symlinks resolved, as git reports it
+func realPath(name string) (string, error) {
+	absolute, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(absolute); err == nil {
+		return resolved, nil
+	}
+
+	return absolute, nil
+}
+
+// git runs git command and returns its output
+func git(args ...string) (string, error) {
+	output, err := exec.Command("git", args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return "", fmt.Errorf("git %v failed: %v", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+	}
+
+	return string(output), err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// gitRepository creates git repository with a given files committed, and returns path to it
+func gitRepository(t *testing.T, files map[string]string) string {
+	root, err := realPath(t.TempDir())
+	require.NoError(t, err)
+
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(path.Dir(path.Join(root, name)), 0755))
+		require.NoError(t, ioutil.WriteFile(path.Join(root, name), []byte(content), 0644))
+	}
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=krane", "-c", "user.email=krane@localhost", "commit", "-q", "-m", "initial"},
+	} {
+		_, err := git(append([]string{"-C", root}, args...)...)
+		require.NoError(t, err)
+	}
+
+	return root
+}
+
+func Test_changedFiles(t *testing.T) {
+	root := gitRepository(t, map[string]string{"a/Dockerfile": "FROM alpine", "b/Dockerfile": "FROM alpine"})
+
+	// nothing changed yet
+	files, err := changedFiles(root, "HEAD")
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	// modified and untracked files count
+	require.NoError(t, ioutil.WriteFile(path.Join(root, "a/Dockerfile"), []byte("FROM ubuntu"), 0644))
+	require.NoError(t, ioutil.WriteFile(path.Join(root, "b/new.txt"), []byte("new"), 0644))
+
+	files, err = changedFiles(path.Join(root, "a"), "HEAD")
+	require.NoError(t, err)
+	sort.Strings(files)
+	require.Equal(t, []string{path.Join(root, "a/Dockerfile"), path.Join(root, "b/new.txt")}, files)
+
+	_, err = changedFiles(root, "no-such-ref")
+	require.Error(t, err)
+}
+
+func Test_selectChanged(t *testing.T) {
+	root := gitRepository(t, map[string]string{
+		"krane.yaml":      "build:\n  - containerName: org/api\n    dockerpath: api\n  - containerName: org/base\n    dockerpath: base\n",
+		"api/Dockerfile":  "FROM org/base",
+		"base/Dockerfile": "FROM alpine",
+	})
+
+	// changes are taken from the repository of the configuration, not from the current folder
+	config, err := LoadFiles([]string{path.Join(root, "krane.yaml")}, nil)
+	require.NoError(t, err)
+	config.Since = "HEAD"
+	require.NoError(t, ioutil.WriteFile(path.Join(root, "api/app.txt"), []byte("new"), 0644))
+
+	namesMap, err := config.NamesMap()
+	require.NoError(t, err)
+
+	selected, err := config.selectChanged(namesMap, Dependencies{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"org/api:latest": true}, selected)
+}
+
+func Test_ownsAnyFile(t *testing.T) {
+	root, err := realPath(t.TempDir())
+	require.NoError(t, err)
+
+	image := Image{ContainerName: "a", Dockerpath: path.Join(root, "a"), DockerfilePath: path.Join(root, "docker/a.Dockerfile"), Folders: []string{path.Join(root, "shared") + ":shared"}}
+	tests := []struct {
+		name string
+		file string
+		want bool
+	}{
+		{"test_0", path.Join(root, "a/main.go"), true},
+		{"test_1", path.Join(root, "docker/a.Dockerfile"), true},
+		{"test_2", path.Join(root, "shared/lib/util.go"), true},
+		{"test_3", path.Join(root, "ab/main.go"), false},
+		{"test_4", path.Join(root, "docker/b.Dockerfile"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owns, err := ownsAnyFile(image, []string{tt.file})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, owns)
+		})
+	}
+}
+
+func Test_withReachable(t *testing.T) {
+	bwdDeps := Dependencies{"a:latest": {"b:latest"}, "b:latest": {"c:latest"}, "c:latest": {}, "d:latest": {}}
+	require.Equal(t, map[string]bool{"b:latest": true, "c:latest": true}, withReachable(map[string]bool{"b:latest": true}, bwdDeps))
+}
+
+func Test_selectTargets(t *testing.T) {
+	// org/base <- org/api <- org/api-tests, org/base <- org/worker, tools is independent
+	config := BuildConfiguration{Images: []Image{{ContainerName: "org/base"}, {ContainerName: "org/api"}, {ContainerName: "org/api-tests:ci"}, {ContainerName: "org/worker"}, {ContainerName: "tools"}}}
+	namesMap, err := config.NamesMap()
+	require.NoError(t, err)
+
+	inDeps := Dependencies{"org/base:latest": {}, "org/api:latest": {"org/base:latest"}, "org/api-tests:ci": {"org/api:latest"}, "org/worker:latest": {"org/base:latest"}, "tools:latest": {}}
+	bwdDeps := Dependencies{"org/base:latest": {"org/api:latest", "org/worker:latest"}, "org/api:latest": {"org/api-tests:ci"}, "org/api-tests:ci": {}, "org/worker:latest": {}, "tools:latest": {}}
+
+	tests := []struct {
+		name       string
+		targets    []string
+		only       bool
+		downstream bool
+		want       []string
+		wantErr    bool
+	}{
+		{"test_0", []string{"org/api"}, false, false, []string{"org/api:latest", "org/base:latest"}, false},
+		{"test_1", []string{"org/api:latest"}, true, false, []string{"org/api:latest"}, false},
+		{"test_2", []string{"org/api"}, true, true, []string{"org/api-tests:ci", "org/api:latest"}, false},
+		{"test_3", []string{"org/base"}, false, true, []string{"org/api-tests:ci", "org/api:latest", "org/base:latest", "org/worker:latest"}, false},
+		{"test_4", []string{"org/api*"}, true, false, []string{"org/api-tests:ci", "org/api:latest"}, false},
+		{"test_5", []string{"tools", "org/w*"}, true, false, []string{"org/worker:latest", "tools:latest"}, false},
+		{"test_6", []string{"org/nothing"}, false, false, nil, true},
+		{"test_7", []string{"org/[api"}, false, false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Targets = tt.targets
+			config.Only = tt.only
+			config.Downstream = tt.downstream
+
+			selected, err := config.selectImages(namesMap, inDeps, bwdDeps)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			var got []string
+			for k := range selected {
+				got = append(got, k)
+			}
+
+			sort.Strings(got)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBuildImages_Targets(t *testing.T) {
+	backend := &fakeBuilder{}
+	defer useFakeBuilder(backend)()
+
+	config := oneRootConfig()
+	config.Targets = []string{"image3"}
+	summary, err := BuildImages(config)
+	require.NoError(t, err)
+	require.Len(t, summary.Built, 2)
+	require.Equal(t, []string{"image2", "image3"}, backend.built)
+}
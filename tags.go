@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// tagVariables are available to tag templates, i.e. org/api:{{.ShortSHA}} or org/api:{{.Env.VERSION}}.
+// Git is asked only for variables that are actually used, and only once per run
+type tagVariables struct {
+	// Env holds environment variables, missing ones are reported as errors
+	Env map[string]string
+
+	// dir is a folder within git repository
+	dir   string
+	now   time.Time
+	mutex sync.Mutex
+	cache map[string]string
+}
+
+// newTagVariables returns variables of the configuration within given folder, git is run there
+func newTagVariables(dir string) *tagVariables {
+	env := make(map[string]string)
+	for _, v := range os.Environ() {
+		split := strings.SplitN(v, "=", 2)
+		env[split[0]] = split[1]
+	}
+
+	return &tagVariables{Env: env, dir: dir, now: time.Now().UTC(), cache: make(map[string]string)}
+}
+
+// GitSHA is a full hash of the current commit
+func (v *tagVariables) GitSHA() (string, error) {
+	return v.git("rev-parse", "HEAD")
+}
+
+// ShortSHA is an abbreviated hash of the current commit
+func (v *tagVariables) ShortSHA() (string, error) {
+	return v.git("rev-parse", "--short", "HEAD")
+}
+
+// Branch is a current branch, with characters that aren't allowed in tags replaced. CI variables are used for detached HEAD
+func (v *tagVariables) Branch() (string, error) {
+	branch, err := v.git("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	if branch == "HEAD" {
+		branch = ""
+		for _, name := range []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "BRANCH_NAME"} {
+			if value := v.Env[name]; len(value) > 0 {
+				branch = value
+				break
+			}
+		}
+	}
+
+	if len(branch) == 0 {
+		return "", fmt.Errorf("current branch is unknown, HEAD is detached")
+	}
+
+	return sanitizeTag(branch), nil
+}
+
+// Describe is an output of git describe, i.e. v1.4.2-3-gabc1234
+func (v *tagVariables) Describe() (string, error) {
+	return v.git("describe", "--tags", "--always", "--dirty")
+}
+
+// Date is a date the run started at, in UTC. Layout of time.Format can be given, i.e. {{.Date "2006-01-02"}}, 20060102 is used otherwise
+func (v *tagVariables) Date(layout ...string) string {
+	if len(layout) > 0 {
+		return v.now.Format(layout[0])
+	}
+
+	return v.now.Format("20060102")
+}
+
+// RunID identifies this run: KRANE_RUN_ID, or ID of the CI pipeline, or start time of the run if there's none
+func (v *tagVariables) RunID() string {
+	for _, name := range []string{"KRANE_RUN_ID", "GITHUB_RUN_ID", "CI_PIPELINE_ID", "BUILD_NUMBER"} {
+		if value := v.Env[name]; len(value) > 0 {
+			return value
+		}
+	}
+
+	return v.now.Format("20060102150405")
+}
+
+// git runs git command once, and remembers its output
+func (v *tagVariables) git(args ...string) (string, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	key := strings.Join(args, " ")
+	if value, has := v.cache[key]; has {
+		return value, nil
+	}
+
+	output, err := git(append([]string{"-C", v.dir}, args...)...)
+	if err != nil {
+		return "", err
+	}
+
+	v.cache[key] = strings.TrimSpace(output)
+	return v.cache[key], nil
+}
+
+// characters that aren't allowed in docker tags
+var invalidTagCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// sanitizeTag makes any string a valid docker tag
+func sanitizeTag(tag string) string {
+	tag = invalidTagCharacters.ReplaceAllString(tag, "-")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+
+	return tag
+}
+
+/*
+This function renders tag templates of every image. Tags without templates are left as is
+*/
+func renderTags(bc *BuildConfiguration, variables *tagVariables) error {
+	for i, image := range bc.Images {
+		for j, tag := range image.Tags {
+			if !strings.Contains(tag, "{{") {
+				continue
+			}
+
+			t, err := template.New(tag).Option("missingkey=error").Parse(tag)
+			if err != nil {
+				return fmt.Errorf("wrong tag [%v] of image [%v]: %v", tag, image.ContainerName, err)
+			}
+
+			var rendered bytes.Buffer
+			err = t.Execute(&rendered, variables)
+			if err != nil {
+				return fmt.Errorf("can't render tag [%v] of image [%v]: %v", tag, image.ContainerName, err)
+			}
+
+			bc.Images[i].Tags[j] = rendered.String()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_renderTags(t *testing.T) {
+	root := gitRepository(t, map[string]string{"Dockerfile": "FROM alpine"})
+	sha, err := git("-C", root, "rev-parse", "HEAD")
+	require.NoError(t, err)
+
+	_, err = git("-C", root, "checkout", "-q", "-b", "feature/tags")
+	require.NoError(t, err)
+
+	_, err = git("-C", root, "tag", "v1.4.2")
+	require.NoError(t, err)
+
+	variables := &tagVariables{
+		Env:   map[string]string{"VERSION": "1.4.2", "GITHUB_RUN_ID": "42"},
+		dir:   root,
+		now:   time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC),
+		cache: make(map[string]string),
+	}
+
+	tests := []struct {
+		name    string
+		tag     string
+		want    string
+		wantErr bool
+	}{
+		{"test_0", "org/api:latest", "org/api:latest", false},
+		{"test_1", "org/api:{{.Env.VERSION}}", "org/api:1.4.2", false},
+		{"test_2", "org/api:sha-{{.ShortSHA}}", "org/api:sha-" + sha[:7], false},
+		{"test_3", "org/api:{{.GitSHA}}", "org/api:" + sha[:40], false},
+		{"test_4", "org/api:{{.Branch}}", "org/api:feature-tags", false},
+		{"test_5", "org/api:{{.Describe}}", "org/api:v1.4.2", false},
+		{"test_6", "org/api:{{.Date}}-{{.RunID}}", "org/api:20210314-42", false},
+		{"test_7", `org/api:{{.Date "2006.01.02"}}`, "org/api:2021.03.14", false},
+		{"test_8", "org/api:{{.Env.MISSING}}", "", true},
+		{"test_9", "org/api:{{.Unknown}}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := BuildConfiguration{Images: []Image{{ContainerName: "org/api", Tags: []string{tt.tag}}}}
+			err := renderTags(&config, variables)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, []string{tt.want}, config.Images[0].Tags)
+		})
+	}
+}
+
+func TestParseFile_TagsRepository(t *testing.T) {
+	// git variables come from the repository of the configuration file, not from the current folder
+	root := gitRepository(t, map[string]string{"build.yaml": "build:\n  - containerName: org/api\n    dockerpath: .\n    tags:\n      - org/api:{{.GitSHA}}\n"})
+	sha, err := git("-C", root, "rev-parse", "HEAD")
+	require.NoError(t, err)
+
+	config, err := ParseFile(path.Join(root, "build.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"org/api:" + strings.TrimSpace(sha)}, config.Images[0].Tags)
+}
+
+func Test_scanDependencies_Tags(t *testing.T) {
+	// image1 and image3 depend on image2:latest, which is just a tag of org/base
+	config := BuildConfiguration{
+		Images: []Image{
+			{ContainerName: "image1", Dockerpath: "./resources/setup_oneroot/Image1"},
+			{ContainerName: "org/base:1.0", Tags: []string{"org/base:sha-abc", "image2"}, Dockerpath: "./resources/setup_oneroot/Image2"},
+			{ContainerName: "image3", Dockerpath: "./resources/setup_oneroot/Image3"},
+		},
+	}
+
+	_, inDeps, bwdDeps, err := scanDependencies(config)
+	require.NoError(t, err)
+	require.Equal(t, []string{"org/base:1.0"}, inDeps["image1:latest"])
+	require.ElementsMatch(t, []string{"image1:latest", "image3:latest"}, bwdDeps["org/base:1.0"])
+
+	namesMap, err := config.NamesMap()
+	require.NoError(t, err)
+
+	config.Targets = []string{"org/base:sha-*"}
+	config.Only = true
+	selected, err := config.selectTargets(namesMap, inDeps, bwdDeps)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"org/base:1.0": true}, selected)
+}
+
+func TestBuildConfiguration_Aliases(t *testing.T) {
+	config := BuildConfiguration{Images: []Image{{ContainerName: "a", Tags: []string{"a:1", "b"}}, {ContainerName: "b:latest"}}}
+	_, err := config.NamesMap()
+	require.EqualError(t, err, "image [b:latest] is declared more than once")
+
+	config.Images[1].ContainerName = "c"
+	aliases, err := config.Aliases()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a:latest": "a:latest", "a:1": "a:latest", "b:latest": "a:latest", "c:latest": "c:latest"}, aliases)
+}
+
+func Test_dockerArgs_Tags(t *testing.T) {
+	image := Image{ContainerName: "org/api:1.4.2", Tags: []string{"org/api:sha-abc", "org/api:latest"}, Dockerpath: "/src/api"}
+	require.Equal(t, []string{"build", "-t", "org/api:1.4.2", "-t", "org/api:sha-abc", "-t", "org/api:latest", "/src/api"}, dockerArgs(image, "/src/api"))
+}
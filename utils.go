@@ -4,38 +4,27 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 )
 
-func ValidatePath(path string, fatal bool) (err error) {
+/*
+	This function checks that build configuration file exists, and is a file
+*/
+func ValidatePath(path string) error {
 	if len(path) == 0 {
-		fmt.Printf("Please specify build configuration file\n")
-		if fatal {
-			os.Exit(1)
-		} else {
-			return fmt.Errorf("missing the filename argument\n")
-		}
+		return fmt.Errorf("please specify build configuration file")
 	}
 
-	// validate the file
-	if d, err := os.Stat(path); err != nil {
-		fmt.Printf("got error [%v] when tried to stat file [%v]\n", err.Error(), path)
-		if fatal {
-			os.Exit(1)
-		} else {
-			return err
-		}
-	} else {
-		if d.IsDir() {
-			fmt.Printf("build configuration must be a file, but got directory instead\n")
-			if fatal {
-				os.Exit(1)
-			} else {
-				return fmt.Errorf("build configuration must be a file, but got directory instead\n")
-			}
-		}
+	d, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("can't read build configuration [%v]: %v", path, err)
 	}
 
-	return
+	if d.IsDir() {
+		return fmt.Errorf("build configuration must be a file, but [%v] is a directory", path)
+	}
+
+	return nil
 }
 
 type sortBy func(p1, p2 *Image) bool
@@ -74,3 +63,57 @@ func SortImages(conf *BuildConfiguration) {
 
 	sortBy(sorter).Sort(conf.Images)
 }
+
+// tail returns last n lines of the given text
+func tail(text string, n int) string {
+	lines := strings.SplitAfter(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "") + "\n"
+}
+
+// sortedKeys returns keys of the map in a stable order
+func sortedKeys(m map[string]string) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return
+}
+
+// keyValueFlag collects repeatable KEY=VALUE command line arguments
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	var pairs []string
+	for _, k := range sortedKeys(f) {
+		pairs = append(pairs, k+"="+f[k])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(value string) error {
+	split := strings.SplitN(value, "=", 2)
+	if len(split) != 2 || len(split[0]) == 0 {
+		return fmt.Errorf("expected KEY=VALUE, got [%v]", value)
+	}
+
+	f[split[0]] = split[1]
+	return nil
+}
+
+// listFlag collects repeatable command line arguments
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
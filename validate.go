@@ -0,0 +1,455 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// referencePattern matches docker image reference: [registry[:port]/]name[/name...][:tag][@digest]
+var referencePattern = func() *regexp.Regexp {
+	// first component is a registry only if it has a dot or a port in it, or is localhost
+	host := `[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?`
+	domain := `(?:localhost(?::[0-9]+)?|` + host + `(?:\.` + host + `)+(?::[0-9]+)?|` + host + `:[0-9]+)`
+	component := `[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*`
+	tag := `[\w][\w.-]{0,127}`
+	digest := `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}`
+	return regexp.MustCompile(`^(?:` + domain + `/)?` + component + `(?:/` + component + `)*(?::` + tag + `)?(?:@` + digest + `)?$`)
+}()
+
+// mergeKey brings fields of other maps into the map, i.e. <<: *base
+const mergeKey = "<<"
+
+// yamlLocation matches location within errors of yaml package, i.e. "yaml: line 3: mapping values are not allowed in this context"
+var yamlLocation = regexp.MustCompile(`line (\d+): (.*)`)
+
+// validator checks configuration files, along with everything they include, and collects all problems found
+type validator struct {
+	vars map[string]string
+
+	// files that were checked already, by absolute path
+	loaded map[string]bool
+
+	// location every image name, tags included, was declared at
+	names map[string]ConfigError
+
+	// number of images found
+	images int
+
+	errs ConfigErrors
+}
+
+/*
+	This function strictly validates given configuration files and everything they include: unknown fields, values of wrong type,
+	required fields, existence of folders and Dockerfiles, syntax of image names and duplicate images.
+	Number of images is returned, along with ConfigErrors that hold every problem found
+*/
+func ValidateFiles(fileNames []string, vars map[string]string) (int, error) {
+	v := validator{vars: vars, loaded: make(map[string]bool), names: make(map[string]ConfigError)}
+	for _, fileName := range fileNames {
+		v.validate(fileName)
+	}
+
+	if len(v.errs) == 0 {
+		return v.images, nil
+	}
+
+	return v.images, v.errs
+}
+
+// validate checks single file, and then files it includes
+func (v *validator) validate(fileName string) {
+	absolute, err := filepath.Abs(fileName)
+	if err != nil || v.loaded[absolute] {
+		return
+	}
+
+	v.loaded[absolute] = true
+	errs, includes := v.check(fileName)
+	for i := range errs {
+		errs[i].File = fileName
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line || (errs[i].Line == errs[j].Line && errs[i].Column < errs[j].Column)
+	})
+
+	// images that share nodes through aliases and merge keys would report the same problem several times
+	reported := make(map[ConfigError]bool)
+	for _, e := range errs {
+		if !reported[e] {
+			reported[e] = true
+			v.errs = append(v.errs, e)
+		}
+	}
+
+	for _, path := range includes {
+		v.validate(path)
+	}
+}
+
+// check reports problems of a single file, and returns files it includes
+func (v *validator) check(fileName string) (errs ConfigErrors, includes []string) {
+	conf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return append(errs, ConfigError{Message: err.Error()}), nil
+	}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(conf, &document)
+	if err != nil {
+		return append(errs, yamlError(err)), nil
+	}
+
+	if len(document.Content) == 0 {
+		return
+	}
+
+	dotEnv, err := readDotEnv(filepath.Join(filepath.Dir(fileName), dotEnvFile))
+	if err != nil {
+		return append(errs, ConfigError{Message: err.Error()}), nil
+	}
+
+	errs = interpolateDocument(&document, variables{overrides: v.vars, dotEnv: dotEnv})
+	if len(errs) > 0 {
+		return
+	}
+
+	root := document.Content[0]
+	errs = checkNode(root, reflect.TypeOf(BuildConfiguration{}), "configuration")
+
+	// values of wrong type were reported already, the rest of configuration is decoded anyway
+	var bc BuildConfiguration
+	if err := root.Decode(&bc); err != nil {
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return
+		}
+	}
+
+	if len(bc.Builder) > 0 {
+		errs = append(errs, checkBuilder(locate(root, "builder"), bc.Builder)...)
+	}
+
+	dir := filepath.Dir(fileName)
+	build := mappingValue(root, "build")
+	for i, image := range bc.Images {
+		image = relativeTo(dir, image)
+
+		// alias is kept as is, so image declared by it is reported at its own position
+		node := root
+		if build != nil && i < len(build.Content) {
+			node = build.Content[i]
+		}
+
+		errs = append(errs, v.checkImage(fileName, node, image)...)
+	}
+
+	v.images += len(bc.Images)
+	for i, include := range bc.Include {
+		path := joinPath(dir, include)
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, newConfigError(item(mappingValue(root, "include"), i, root), "can't include [%v]: %v", path, err))
+			continue
+		}
+
+		includes = append(includes, path)
+	}
+
+	return
+}
+
+// checkImage reports every problem of a single image
+func (v *validator) checkImage(fileName string, node *yaml.Node, image Image) (errs ConfigErrors) {
+	if len(image.ContainerName) == 0 {
+		errs = append(errs, newConfigError(node, "image must have containerName"))
+	} else {
+		errs = append(errs, checkReference(locate(node, "containerName"), image.ContainerName)...)
+	}
+
+	// tags are checked once rendered
+	if len(image.Tags) > 0 {
+		tags := locate(node, "tags")
+		rendered := BuildConfiguration{Images: []Image{image}}
+		rendered.Images[0].Tags = append([]string{}, image.Tags...)
+		if err := renderTags(&rendered, newTagVariables(filepath.Dir(fileName))); err != nil {
+			errs = append(errs, newConfigError(tags, "%v", err))
+		} else {
+			image.Tags = rendered.Images[0].Tags
+			for i, tag := range image.Tags {
+				errs = append(errs, checkReference(item(tags, i, tags), tag)...)
+			}
+		}
+	}
+
+	if len(image.ContainerName) > 0 {
+		for _, name := range image.Names() {
+			if first, has := v.names[name]; has {
+				errs = append(errs, newConfigError(node, "image [%v] is already declared at %v:%v:%v", name, first.File, first.Line, first.Column))
+				continue
+			}
+
+			v.names[name] = ConfigError{File: fileName, Line: node.Line, Column: node.Column}
+		}
+	}
+
+	if len(image.Dockerpath) == 0 {
+		errs = append(errs, newConfigError(node, "image must have dockerpath"))
+	} else if err := checkDirectory(image.Dockerpath); err != nil {
+		errs = append(errs, newConfigError(locate(node, "dockerpath"), "dockerpath %v", err))
+	} else if f, err := os.Stat(image.ResolvedDockerfile()); err != nil || f.IsDir() {
+		location := mappingValue(node, "dockerfile")
+		if location == nil {
+			location = locate(node, "dockerpath")
+		}
+
+		errs = append(errs, newConfigError(location, "Dockerfile [%v] doesn't exist", image.ResolvedDockerfile()))
+	}
+
+	if len(image.Context) > 0 {
+		if err := checkDirectory(image.Context); err != nil {
+			errs = append(errs, newConfigError(locate(node, "context"), "context %v", err))
+		}
+	}
+
+	for i, v := range image.Folders {
+		location := item(mappingValue(node, "folders"), i, node)
+		folder, err := NewFolder(v)
+		if err != nil {
+			errs = append(errs, newConfigError(location, "%v", err))
+		} else if err = checkDirectory(folder.Source); err != nil {
+			errs = append(errs, newConfigError(location, "folder %v", err))
+		}
+	}
+
+	if len(image.Builder) > 0 {
+		errs = append(errs, checkBuilder(locate(node, "builder"), image.Builder)...)
+	}
+
+	return
+}
+
+/*
+	This function checks that node matches given type: every field of the map is known, and every value can be decoded.
+	Unknown fields are reported along with the known one they most likely meant
+*/
+func checkNode(node *yaml.Node, t reflect.Type, what string) (errs ConfigErrors) {
+	node = resolveAlias(node)
+
+	if node.Tag == "!!null" {
+		return
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return append(errs, newConfigError(node, "%v must be a map", what))
+		}
+
+		fields := make(map[string]reflect.StructField)
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			} else if len(name) == 0 {
+				name = strings.ToLower(t.Field(i).Name)
+			}
+
+			fields[name] = t.Field(i)
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+
+			// merge key brings fields of other maps, i.e. <<: *base or <<: [*base, *other]
+			if key == mergeKey {
+				errs = append(errs, checkMerged(node.Content[i+1], t, what)...)
+				continue
+			}
+
+			field, has := fields[key]
+			if has {
+				errs = append(errs, checkNode(node.Content[i+1], field.Type, key)...)
+				continue
+			}
+
+			message := fmt.Sprintf("unknown field [%v] of %v", key, what)
+			for name := range fields {
+				if strings.EqualFold(name, key) {
+					message += fmt.Sprintf(", did you mean [%v]?", name)
+				}
+			}
+
+			errs = append(errs, newConfigError(node.Content[i], "%v", message))
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return append(errs, newConfigError(node, "%v must be a list", what))
+		}
+
+		// every image is a struct of its own
+		if what == "build" {
+			what = "image"
+		}
+
+		for _, v := range node.Content {
+			errs = append(errs, checkNode(v, t.Elem(), what)...)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return append(errs, newConfigError(node, "%v must be a map", what))
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == mergeKey {
+				errs = append(errs, checkMerged(node.Content[i+1], t, what)...)
+				continue
+			}
+
+			errs = append(errs, checkNode(node.Content[i+1], t.Elem(), node.Content[i].Value)...)
+		}
+	default:
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			message := err.Error()
+			if match := yamlLocation.FindStringSubmatch(message); match != nil {
+				message = match[2]
+			}
+
+			errs = append(errs, newConfigError(node, "wrong value of [%v]: %v", what, message))
+		}
+	}
+
+	return
+}
+
+// checkMerged checks value of the merge key, either a single map or a list of them, against the type of the map it's merged into
+func checkMerged(node *yaml.Node, t reflect.Type, what string) (errs ConfigErrors) {
+	node = resolveAlias(node)
+	if node.Kind != yaml.SequenceNode {
+		return checkNode(node, t, what)
+	}
+
+	for _, v := range node.Content {
+		errs = append(errs, checkNode(v, t, what)...)
+	}
+
+	return
+}
+
+// checkReference reports name that isn't a valid docker image reference
+func checkReference(node *yaml.Node, reference string) ConfigErrors {
+	if len(reference) > 255 || !referencePattern.MatchString(reference) {
+		return ConfigErrors{newConfigError(node, "[%v] is not a valid image reference", reference)}
+	}
+
+	return nil
+}
+
+// checkBuilder reports backend that isn't known
+func checkBuilder(node *yaml.Node, name string) ConfigErrors {
+	if _, has := builders[name]; !has {
+		return ConfigErrors{newConfigError(node, "unknown builder [%v]", name)}
+	}
+
+	return nil
+}
+
+// checkDirectory returns error if there's no such directory
+func checkDirectory(path string) error {
+	f, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("[%v] doesn't exist", path)
+	} else if err != nil {
+		return err
+	} else if !f.IsDir() {
+		return fmt.Errorf("[%v] is not a directory", path)
+	}
+
+	return nil
+}
+
+// mappingValue returns value of the given key within the map node, or nil if there's no such key.
+// Aliases are resolved, and keys brought by merge keys are found as well
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+
+	node = resolveAlias(node)
+	var merged []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolveAlias(node.Content[i+1])
+		}
+
+		if node.Content[i].Value == mergeKey {
+			merged = append(merged, node.Content[i+1])
+		}
+	}
+
+	// keys of the map itself take precedence over merged ones, and earlier merged maps take precedence over later ones
+	for _, v := range merged {
+		v = resolveAlias(v)
+		sources := []*yaml.Node{v}
+		if v.Kind == yaml.SequenceNode {
+			sources = v.Content
+		}
+
+		for _, source := range sources {
+			if value := mappingValue(source, key); value != nil {
+				return value
+			}
+		}
+	}
+
+	return nil
+}
+
+// locate returns value of the given key within the map node, or the map node itself if there's no such key, so errors still have a location
+func locate(node *yaml.Node, key string) *yaml.Node {
+	if value := mappingValue(node, key); value != nil {
+		return value
+	}
+
+	return node
+}
+
+// item returns element of the list node, with alias resolved, or fallback if there's no such element
+func item(list *yaml.Node, i int, fallback *yaml.Node) *yaml.Node {
+	if list == nil || list.Kind != yaml.SequenceNode || i >= len(list.Content) {
+		return fallback
+	}
+
+	return resolveAlias(list.Content[i])
+}
+
+// resolveAlias returns node the alias refers to, other nodes are returned as is
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// yamlError converts syntax error of yaml package into ConfigError, keeping the line it happened at
+func yamlError(err error) ConfigError {
+	match := yamlLocation.FindStringSubmatch(err.Error())
+	if match == nil {
+		return ConfigError{Message: err.Error()}
+	}
+
+	line, _ := strconv.Atoi(match[1])
+	return ConfigError{Line: line, Column: 1, Message: match[2]}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFiles(t *testing.T) {
+	root := writeConfigs(t, map[string]string{
+		"api/Dockerfile":          "FROM alpine",
+		"assets/logo.png":         "",
+		"services/web/Dockerfile": "FROM org/api",
+		"valid.yaml": `
+threads: 2
+include:
+  - services/krane.yaml
+build:
+  - containerName: org/api
+    dockerpath: ./api
+    noCache: true
+    folders:
+      - ./assets:/assets
+    tags:
+      - localhost:5000/org/api:1.0
+`,
+		"services/krane.yaml": `
+build:
+  - containerName: org/web
+    dockerpath: ./web
+`,
+		"typos.yaml": `
+threads: two
+build:
+  - containerName: org/api
+    dockerPath: ./api
+    nocache: true
+    output:
+      quiet: true
+`,
+		"paths.yaml": `
+build:
+  - containerName: org/api
+    dockerpath: ./missing
+  - containerName: org/other
+    dockerpath: ./api
+    dockerfile: Dockerfile.dev
+  - containerName: org/assets
+    dockerpath: ./api
+    context: ./assets/logo.png
+    folders:
+      - ./gone
+      - a:b:c
+`,
+		"names.yaml": `
+builder: kaniko
+build:
+  - dockerpath: ./api
+  - containerName: Org/API
+    dockerpath: ./api
+  - containerName: org/api
+    dockerpath: ./api
+  - containerName: org/api:latest
+    dockerpath: ./api
+    tags:
+      - "org/api:bad tag"
+`,
+		"include.yaml": `
+include:
+  - missing.yaml
+  - names.yaml
+build:
+  - containerName: org/api
+    dockerpath: ./api
+    push: maybe
+`,
+		"broken.yaml": "build:\n  - containerName: org/api\n   dockerpath: ./api\n",
+		"anchors.yaml": `
+build:
+  - &base
+    containerName: org/api
+    dockerpath: ./api
+    folders:
+      - ./assets:/assets
+    tags:
+      - org/api:1.0
+  - <<: *base
+    containerName: org/web
+    tags:
+      - org/web:1.0
+  - containerName: org/worker
+    <<: [*base]
+    tags: []
+    buildArgs:
+      <<: {VERSION: "1"}
+`,
+		"merged.yaml": `
+build:
+  - &base
+    containerName: org/api
+    dockerpath: ./api
+    folders:
+      - ./gone
+  - *base
+  - <<: *base
+    containerName: org/web
+    nocache: true
+`,
+		"vars.yaml": `
+build:
+  - containerName: org/api
+    dockerpath: ${SRC_ROOT}/api
+`,
+	})
+
+	tests := []struct {
+		name       string
+		files      []string
+		wantImages int
+		want       []string
+	}{
+		{"test_0", []string{"valid.yaml"}, 2, nil},
+		{"test_1", []string{"typos.yaml"}, 1, []string{
+			"typos.yaml:2:10: wrong value of [threads]: cannot unmarshal !!str `two` into int",
+			"typos.yaml:4:5: image must have dockerpath",
+			"typos.yaml:5:5: unknown field [dockerPath] of image, did you mean [dockerpath]?",
+			"typos.yaml:6:5: unknown field [nocache] of image, did you mean [noCache]?",
+			"typos.yaml:7:5: unknown field [output] of image",
+		}},
+		{"test_2", []string{"paths.yaml"}, 3, []string{
+			"paths.yaml:4:17: dockerpath [missing] doesn't exist",
+			"paths.yaml:7:17: Dockerfile [api/Dockerfile.dev] doesn't exist",
+			"paths.yaml:10:14: context [assets/logo.png] is not a directory",
+			"paths.yaml:12:9: folder [gone] doesn't exist",
+			"paths.yaml:13:9: wrong folder format: [a:b:c]",
+		}},
+		{"test_3", []string{"names.yaml"}, 4, []string{
+			"names.yaml:2:10: unknown builder [kaniko]",
+			"names.yaml:4:5: image must have containerName",
+			"names.yaml:5:20: [Org/API] is not a valid image reference",
+			"names.yaml:9:5: image [org/api:latest] is already declared at names.yaml:7:5",
+			"names.yaml:12:9: [org/api:bad tag] is not a valid image reference",
+		}},
+		// included files are reported after the one that includes them, duplicates are found across files
+		{"test_4", []string{"include.yaml"}, 5, []string{
+			"include.yaml:3:5: can't include [missing.yaml]: stat missing.yaml: no such file or directory",
+			"include.yaml:8:11: wrong value of [push]: cannot unmarshal !!str `maybe` into bool",
+			"names.yaml:2:10: unknown builder [kaniko]",
+			"names.yaml:4:5: image must have containerName",
+			"names.yaml:5:20: [Org/API] is not a valid image reference",
+			"names.yaml:7:5: image [org/api:latest] is already declared at include.yaml:6:5",
+			"names.yaml:9:5: image [org/api:latest] is already declared at include.yaml:6:5",
+			"names.yaml:12:9: [org/api:bad tag] is not a valid image reference",
+		}},
+		{"test_5", []string{"broken.yaml"}, 0, []string{
+			"broken.yaml:1:1: did not find expected '-' indicator",
+		}},
+		{"test_6", []string{"vars.yaml"}, 0, []string{
+			"vars.yaml:4:17: variable [SRC_ROOT] is not set",
+		}},
+		// aliases and merge keys work as they do within the build itself
+		{"test_8", []string{"anchors.yaml"}, 3, nil},
+		{"test_9", []string{"merged.yaml"}, 3, []string{
+			"merged.yaml:7:9: folder [gone] doesn't exist",
+			"merged.yaml:8:5: image [org/api:latest] is already declared at merged.yaml:3:5",
+			"merged.yaml:11:5: unknown field [nocache] of image, did you mean [noCache]?",
+		}},
+		{"test_7", []string{"missing.yaml"}, 0, []string{
+			"missing.yaml: open missing.yaml: no such file or directory",
+		}},
+	}
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(root))
+	defer os.Chdir(wd)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := ValidateFiles(tt.files, nil)
+			require.Equal(t, tt.wantImages, images)
+			if len(tt.want) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			require.IsType(t, ConfigErrors{}, err)
+			var got []string
+			for _, v := range err.(ConfigErrors) {
+				got = append(got, v.Error())
+			}
+
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_referencePattern(t *testing.T) {
+	tests := []struct {
+		name      string
+		reference string
+		want      bool
+	}{
+		{"test_0", "alpine", true},
+		{"test_1", "org/api:latest", true},
+		{"test_2", "localhost:5000/org/api:1.0.2-rc_1", true},
+		{"test_3", "registry.example.com/team/org/api", true},
+		{"test_4", "org/api@sha256:4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945", true},
+		{"test_5", "my_org/api-server", true},
+		{"test_6", "Org/api", false},
+		{"test_7", "org/api:", false},
+		{"test_8", "org//api", false},
+		{"test_9", "org/api:-dev", false},
+		{"test_10", "org/-api", false},
+		{"test_11", "org/api:latest:dev", false},
+		{"test_12", "Registry.Example.com/org/api", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, referencePattern.MatchString(tt.reference))
+		})
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"test_0", "./resources/test.yaml", false},
+		{"test_1", "", true},
+		{"test_2", "./resources/missing.yaml", true},
+		{"test_3", "./resources", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePath(tt.path)
+			require.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
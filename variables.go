@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// name of the file with variables, looked up next to the configuration file
+const dotEnvFile = ".env"
+
+// ConfigError is a problem of the configuration, along with its location
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Line == 0 && len(e.File) > 0 {
+		return fmt.Sprintf("%v: %v", e.File, e.Message)
+	} else if e.Line == 0 {
+		return e.Message
+	}
+
+	if len(e.File) > 0 {
+		return fmt.Sprintf("%v:%v:%v: %v", e.File, e.Line, e.Column, e.Message)
+	}
+
+	return fmt.Sprintf("%v:%v: %v", e.Line, e.Column, e.Message)
+}
+
+// ConfigErrors are all problems found in the configuration
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	var lines []string
+	for _, v := range e {
+		lines = append(lines, v.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// newConfigError creates error located at a given node
+func newConfigError(node *yaml.Node, format string, args ...interface{}) ConfigError {
+	return ConfigError{Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)}
+}
+
+// variables hold values for ${VAR} interpolation. Command line values take precedence over environment,
+// environment takes precedence over .env file, and vars block of the configuration has the lowest priority
+type variables struct {
+	overrides map[string]string
+	dotEnv    map[string]string
+	block     map[string]string
+}
+
+func (v variables) lookup(name string) (string, bool) {
+	if value, has := v.overrides[name]; has {
+		return value, true
+	}
+
+	if value, has := os.LookupEnv(name); has {
+		return value, true
+	}
+
+	if value, has := v.dotEnv[name]; has {
+		return value, true
+	}
+
+	value, has := v.block[name]
+	return value, has
+}
+
+/*
+	This function reads variables from .env file: KEY=VALUE per line, with optional export keyword and quotes.
+	Missing file means there are no variables
+*/
+func readDotEnv(fileName string) (result map[string]string, err error) {
+	result = make(map[string]string)
+	content, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return
+	}
+
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		split := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(split) != 2 || len(strings.TrimSpace(split[0])) == 0 {
+			return result, ConfigError{File: fileName, Line: i + 1, Column: 1, Message: fmt.Sprintf("expected KEY=VALUE, got [%v]", line)}
+		}
+
+		result[strings.TrimSpace(split[0])] = unquote(strings.TrimSpace(split[1]))
+	}
+
+	return
+}
+
+/*
+	This function interpolates ${VAR} and ${VAR:-default} in every scalar of the document, $$ stands for a single $.
+	Variables declared in vars block of the document can be used as well, and can refer to other sources themselves.
+	Every variable that can't be resolved is reported with its location
+*/
+func interpolateDocument(document *yaml.Node, vars variables) (errs ConfigErrors) {
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+
+	// vars block goes first, so the rest of the document can use it
+	var block *yaml.Node
+	if root.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value == "vars" {
+				block = root.Content[i+1]
+			}
+		}
+	}
+
+	if block != nil {
+		errs = append(errs, interpolateNode(block, vars, nil)...)
+		if len(errs) > 0 {
+			return
+		}
+
+		vars.block = make(map[string]string)
+		if err := block.Decode(&vars.block); err != nil {
+			return append(errs, newConfigError(block, "vars must be a map of strings: %v", err))
+		}
+	}
+
+	return append(errs, interpolateNode(document, vars, block)...)
+}
+
+// interpolateNode interpolates all scalars within the node, except for the skipped one
+func interpolateNode(node *yaml.Node, vars variables, skip *yaml.Node) (errs ConfigErrors) {
+	if node == skip {
+		return
+	}
+
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "$") {
+		value, missing := interpolate(node.Value, vars)
+		for _, v := range missing {
+			errs = append(errs, newConfigError(node, "variable [%v] is not set", v))
+		}
+
+		// type of plain scalar is resolved once again, so ${NO_CACHE:-false} is a boolean
+		node.Value = value
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	}
+
+	for _, v := range node.Content {
+		errs = append(errs, interpolateNode(v, vars, skip)...)
+	}
+
+	return
+}
+
+/*
+	This function interpolates variables within a single value, and returns names of variables that weren't resolved
+*/
+func interpolate(value string, vars variables) (string, []string) {
+	var result strings.Builder
+	missing := make(map[string]bool)
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		// $$ is an escaped $
+		if value[i+1] == '$' {
+			result.WriteByte('$')
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(value[i:], '}')
+		if value[i+1] != '{' || end < 0 {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		expression := value[i+2 : i+end]
+		name, fallback, hasFallback := expression, "", false
+		if split := strings.SplitN(expression, ":-", 2); len(split) == 2 {
+			name, fallback, hasFallback = split[0], split[1], true
+		}
+
+		resolved, has := vars.lookup(name)
+		switch {
+		case has && (len(resolved) > 0 || !hasFallback):
+			result.WriteString(resolved)
+		case hasFallback:
+			result.WriteString(fallback)
+		default:
+			missing[name] = true
+		}
+
+		i += end
+	}
+
+	var names []string
+	for k := range missing {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+	return result.String(), names
+}
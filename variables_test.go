@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_interpolate(t *testing.T) {
+	vars := variables{
+		overrides: map[string]string{"TAG": "cli"},
+		dotEnv:    map[string]string{"TAG": "dotenv", "REGISTRY": "localhost:5000", "EMPTY": ""},
+		block:     map[string]string{"REGISTRY": "block", "ORG": "org"},
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		missing []string
+	}{
+		{"test_0", "org/api", "org/api", nil},
+		{"test_1", "${REGISTRY}/${ORG}/api:${TAG}", "localhost:5000/org/api:cli", nil},
+		{"test_2", "${MISSING:-default}", "default", nil},
+		{"test_3", "${EMPTY:-default}", "default", nil},
+		{"test_4", "${EMPTY}", "", nil},
+		{"test_5", "$$HOME and $HOME", "$HOME and $HOME", nil},
+		{"test_6", "${MISSING}/${OTHER}/${MISSING}", "//", []string{"MISSING", "OTHER"}},
+		{"test_7", "unterminated ${TAG", "unterminated ${TAG", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, missing := interpolate(tt.value, vars)
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.missing, missing)
+		})
+	}
+}
+
+func TestParseFileWithVars(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".env"), []byte("# registry\nexport REGISTRY=\"localhost:5000\"\nNO_CACHE=true\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "build.yaml"), []byte(`vars:
+  ORG: org
+  ROOT: ${KRANE_TEST_ROOT:-/src}
+build:
+  - containerName: ${REGISTRY}/${ORG}/api:${TAG}
+    dockerpath: ${ROOT}/api
+    noCache: ${NO_CACHE}
+threads: ${THREADS:-3}
+`), 0644))
+
+	config, err := ParseFileWithVars(filepath.Join(dir, "build.yaml"), map[string]string{"TAG": "1.0"})
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/org/api:1.0", config.Images[0].ContainerName)
+	require.Equal(t, "/src/api", config.Images[0].Dockerpath)
+	require.True(t, config.Images[0].ForbidCache)
+	require.Equal(t, 3, config.Threads)
+
+	// environment takes precedence over vars block
+	require.NoError(t, os.Setenv("KRANE_TEST_ROOT", "/work"))
+	defer os.Unsetenv("KRANE_TEST_ROOT")
+
+	config, err = ParseFileWithVars(filepath.Join(dir, "build.yaml"), map[string]string{"TAG": "1.0"})
+	require.NoError(t, err)
+	require.Equal(t, "/work/api", config.Images[0].Dockerpath)
+}
+
+func TestParseFileWithVars_Unresolved(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "build.yaml")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(`build:
+  - containerName: org/api:${TAG}
+    dockerpath: ${ROOT}/api
+`), 0644))
+
+	_, err := ParseFileWithVars(fileName, nil)
+	require.EqualError(t, err, fileName+":2:20: variable [TAG] is not set\n"+fileName+":3:17: variable [ROOT] is not set")
+}
+
+func Test_readDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	vars, err := readDotEnv(filepath.Join(dir, ".env"))
+	require.NoError(t, err)
+	require.Empty(t, vars)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".env"), []byte("A=1\nB='two words'\n\nbroken\n"), 0644))
+	_, err = readDotEnv(filepath.Join(dir, ".env"))
+	require.EqualError(t, err, filepath.Join(dir, ".env")+":4:1: expected KEY=VALUE, got [broken]")
+}